@@ -5,10 +5,45 @@ import (
 	"strings"
 )
 
+// Message size limits
+const (
+	// MaxUDPSize is the largest message RFC 1035 allows over UDP without EDNS0
+	MaxUDPSize = 512
+	// MaxMessageSize is the largest message a two byte length can describe
+	MaxMessageSize = 65535
+)
+
 // BytePacketBuffer is a structure for manipulating DNS packets.
+// The buffer grows as it is written to, up to its limit.
 type BytePacketBuffer struct {
-	buf [512]byte
-	pos uint32
+	buf   []byte
+	pos   uint32
+	limit uint32
+
+	// names maps each name suffix already written to its offset
+	names map[string]uint32
+	// canonical writes every name uncompressed and in lowercase
+	canonical bool
+}
+
+// NewBytePacketBuffer creates an empty buffer that refuses writes past limit bytes.
+func NewBytePacketBuffer(limit uint32) *BytePacketBuffer {
+	if limit == 0 || limit > MaxMessageSize {
+		limit = MaxMessageSize
+	}
+
+	return &BytePacketBuffer{buf: make([]byte, 0, limit), limit: limit}
+}
+
+// newCanonicalBuffer creates a buffer that writes records in the canonical
+// form DNSSEC signs and digests (RFC 4034 section 6.2).
+func newCanonicalBuffer() *BytePacketBuffer {
+	return &BytePacketBuffer{buf: make([]byte, 0, MaxUDPSize), limit: MaxMessageSize, canonical: true}
+}
+
+// NewBytePacketBufferFrom wraps a received message so it can be read.
+func NewBytePacketBufferFrom(data []byte) *BytePacketBuffer {
+	return &BytePacketBuffer{buf: data, limit: MaxMessageSize}
 }
 
 // InvalidInput error
@@ -18,6 +53,29 @@ func (e InvalidInput) Error() string {
 	return string(e)
 }
 
+// Limit returns the maximum number of bytes this buffer will hold.
+func (bytePacketBuffer *BytePacketBuffer) Limit() uint32 {
+	if bytePacketBuffer.limit == 0 {
+		return MaxMessageSize
+	}
+
+	return bytePacketBuffer.limit
+}
+
+// Len returns the number of bytes held by the buffer.
+func (bytePacketBuffer *BytePacketBuffer) Len() uint32 {
+	return uint32(len(bytePacketBuffer.buf))
+}
+
+// Bytes returns the bytes written so far, up to the current position.
+func (bytePacketBuffer *BytePacketBuffer) Bytes() []byte {
+	if bytePacketBuffer.pos > bytePacketBuffer.Len() {
+		return bytePacketBuffer.buf
+	}
+
+	return bytePacketBuffer.buf[:bytePacketBuffer.pos]
+}
+
 // Pos reads the current position of our buffer.
 func (bytePacketBuffer *BytePacketBuffer) Pos() uint32 {
 	return bytePacketBuffer.pos
@@ -37,7 +95,7 @@ func (bytePacketBuffer *BytePacketBuffer) Seek(pos uint32) error {
 
 // Read reads a single byte in the buffer and moves one step forward.
 func (bytePacketBuffer *BytePacketBuffer) Read() (byte, error) {
-	if bytePacketBuffer.pos >= 512 {
+	if bytePacketBuffer.pos >= bytePacketBuffer.Len() {
 		return 0, InvalidInput(fmt.Sprintf("End of buffer. Failed at position %d.", bytePacketBuffer.pos))
 	}
 
@@ -49,7 +107,7 @@ func (bytePacketBuffer *BytePacketBuffer) Read() (byte, error) {
 
 // Get one byte without stepping through the buffer
 func (bytePacketBuffer *BytePacketBuffer) Get(pos uint32) (byte, error) {
-	if pos >= 512 {
+	if pos >= bytePacketBuffer.Len() {
 		return 0, InvalidInput(fmt.Sprintf("End of buffer. Failed at position %d.", pos))
 	}
 
@@ -58,7 +116,7 @@ func (bytePacketBuffer *BytePacketBuffer) Get(pos uint32) (byte, error) {
 
 // GetRange retrieves a range of bytes without stepping through the buffer
 func (bytePacketBuffer *BytePacketBuffer) GetRange(start uint32, len uint32) ([]byte, error) {
-	if start+len >= 512 {
+	if start+len > bytePacketBuffer.Len() {
 		return nil, InvalidInput(fmt.Sprintf("End of buffer. Failed at between position %d and %d.", start, start+len))
 	}
 
@@ -109,6 +167,15 @@ func (bytePacketBuffer *BytePacketBuffer) ReadU32() (uint32, error) {
 
 // ReadQName returns the domain name for the record.
 func (bytePacketBuffer *BytePacketBuffer) ReadQName() (string, error) {
+	return bytePacketBuffer.readName(true)
+}
+
+// readExactQName returns a domain name in the case it was sent in
+func (bytePacketBuffer *BytePacketBuffer) readExactQName() (string, error) {
+	return bytePacketBuffer.readName(false)
+}
+
+func (bytePacketBuffer *BytePacketBuffer) readName(lowercase bool) (string, error) {
 	pos := bytePacketBuffer.pos
 	jumped := false
 	delimiter := ""
@@ -129,7 +196,11 @@ func (bytePacketBuffer *BytePacketBuffer) ReadQName() (string, error) {
 			if err != nil {
 				return "", err
 			}
+			// Pointers must lead backwards, or a packet could send us round in circles
 			offset := (uint16(len)^0xC0)<<8 | uint16(low)
+			if uint32(offset) >= pos {
+				return "", InvalidInput(fmt.Sprintf("Compression pointer at %d does not point backwards", pos))
+			}
 			pos = uint32(offset)
 			jumped = true
 		} else {
@@ -141,9 +212,13 @@ func (bytePacketBuffer *BytePacketBuffer) ReadQName() (string, error) {
 			out += delimiter
 			label, err := bytePacketBuffer.GetRange(pos, uint32(len))
 			if err != nil {
-				return "", nil
+				return "", err
+			}
+			if lowercase {
+				out += strings.ToLower(string(label))
+			} else {
+				out += string(label)
 			}
-			out += strings.ToLower(string(label))
 			delimiter = "."
 			pos += uint32(len)
 		}
@@ -156,11 +231,43 @@ func (bytePacketBuffer *BytePacketBuffer) ReadQName() (string, error) {
 	return out, nil
 }
 
+// ReadCharacterString reads a string prefixed with its one byte length
+func (bytePacketBuffer *BytePacketBuffer) ReadCharacterString() (string, error) {
+	len, err := bytePacketBuffer.Read()
+	if err != nil {
+		return "", err
+	}
+
+	text, err := bytePacketBuffer.GetRange(bytePacketBuffer.pos, uint32(len))
+	if err != nil {
+		return "", err
+	}
+	bytePacketBuffer.pos += uint32(len)
+
+	return string(text), nil
+}
+
+// readRest reads every byte from the current position up to end
+func (bytePacketBuffer *BytePacketBuffer) readRest(end uint32) ([]byte, error) {
+	if end < bytePacketBuffer.pos {
+		return nil, InvalidInput(fmt.Sprintf("Record data overran its length at position %d.", bytePacketBuffer.pos))
+	}
+
+	data, err := bytePacketBuffer.GetRange(bytePacketBuffer.pos, end-bytePacketBuffer.pos)
+	if err != nil {
+		return nil, err
+	}
+	bytePacketBuffer.pos = end
+
+	return data, nil
+}
+
 func (bytePacketBuffer *BytePacketBuffer) write(val byte) error {
-	if bytePacketBuffer.pos >= 512 {
+	if bytePacketBuffer.pos >= bytePacketBuffer.Limit() {
 		return InvalidInput(fmt.Sprintf("End of buffer. Failed at position %d.", bytePacketBuffer.pos))
 	}
 
+	bytePacketBuffer.grow(bytePacketBuffer.pos + 1)
 	bytePacketBuffer.buf[bytePacketBuffer.pos] = val
 	bytePacketBuffer.pos++
 	return nil
@@ -202,39 +309,156 @@ func (bytePacketBuffer *BytePacketBuffer) writeU32(val uint32) error {
 	return nil
 }
 
+// writeQName writes a domain name, replacing any suffix already present in
+// the buffer with a pointer to it (RFC 1035 section 4.1.4).
 func (bytePacketBuffer *BytePacketBuffer) writeQName(qname string) error {
-	labels := strings.Split(qname, ".")
-	undo := 0
+	return bytePacketBuffer.writeName(qname, true)
+}
+
+// writeUncompressedQName writes every label of a domain name in full. Record
+// types defined after RFC 1035 must not compress the names in their RDATA.
+func (bytePacketBuffer *BytePacketBuffer) writeUncompressedQName(qname string) error {
+	return bytePacketBuffer.writeName(qname, false)
+}
+
+// writeExactQName writes a name uncompressed and in its original case, even
+// in canonical form. Only the RDATA types RFC 4034 section 6.2 lists have
+// their names lowercased, and RFC 6840 section 5.1 takes NSEC off that list.
+func (bytePacketBuffer *BytePacketBuffer) writeExactQName(qname string) error {
+	canonical := bytePacketBuffer.canonical
+	bytePacketBuffer.canonical = false
+	defer func() { bytePacketBuffer.canonical = canonical }()
+
+	return bytePacketBuffer.writeName(qname, false)
+}
+
+func (bytePacketBuffer *BytePacketBuffer) writeName(qname string, compress bool) error {
+	startPos := bytePacketBuffer.pos
+	qname = strings.TrimSuffix(qname, ".")
+	if bytePacketBuffer.canonical {
+		qname = strings.ToLower(qname)
+		compress = false
+	}
+	labels := []string{}
+	if len(qname) > 0 {
+		labels = strings.Split(qname, ".")
+	}
+
+	// Each label costs its length byte, and the root label one more
+	encoded := 1
+	for _, label := range labels {
+		if len(label) == 0 {
+			return InvalidInput(fmt.Sprintf("Empty label in %q", qname))
+		}
+		encoded += len(label) + 1
+	}
+	if encoded > 255 {
+		return InvalidInput(fmt.Sprintf("Name exceeds 255 octets: %q", qname))
+	}
+
+	written := make(map[string]uint32)
+	pointer := false
 	for idx, label := range labels {
+		suffix := strings.ToLower(strings.Join(labels[idx:], "."))
+		if offset, ok := bytePacketBuffer.names[suffix]; ok && compress {
+			if err := bytePacketBuffer.writeU16(0xC000 | uint16(offset)); err != nil {
+				bytePacketBuffer.pos = startPos
+				return err
+			}
+			pointer = true
+			break
+		}
+
 		len := len(label)
 		if len > 63 {
+			bytePacketBuffer.pos = startPos
 			return InvalidInput("Single label exceeds 63 characters of length")
 		}
 
+		// Pointers only have fourteen bits for the offset
+		if bytePacketBuffer.pos < 0x4000 {
+			written[suffix] = bytePacketBuffer.pos
+		}
+
 		if err := bytePacketBuffer.write(byte(len)); err != nil {
-			bytePacketBuffer.pos -= uint32(idx + undo)
+			bytePacketBuffer.pos = startPos
 			return err
 		}
 
-		for byteIdx, b := range []byte(label) {
+		for _, b := range []byte(label) {
 			if err := bytePacketBuffer.write(b); err != nil {
-				bytePacketBuffer.pos -= uint32(idx + byteIdx + undo + 1)
+				bytePacketBuffer.pos = startPos
 				return err
 			}
 		}
-		undo += len
 	}
 
-	if err := bytePacketBuffer.write(0); err != nil {
-		bytePacketBuffer.pos -= uint32(len(labels) + undo)
+	if !pointer {
+		if err := bytePacketBuffer.write(0); err != nil {
+			bytePacketBuffer.pos = startPos
+			return err
+		}
+	}
+
+	if bytePacketBuffer.names == nil {
+		bytePacketBuffer.names = make(map[string]uint32)
+	}
+	for suffix, offset := range written {
+		if _, ok := bytePacketBuffer.names[suffix]; !ok {
+			bytePacketBuffer.names[suffix] = offset
+		}
+	}
+
+	return nil
+}
+
+// writeBytes writes a run of raw bytes
+func (bytePacketBuffer *BytePacketBuffer) writeBytes(data []byte) error {
+	startPos := bytePacketBuffer.pos
+	for _, b := range data {
+		if err := bytePacketBuffer.write(b); err != nil {
+			bytePacketBuffer.pos = startPos
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeCharacterString writes a string of at most 255 bytes prefixed with its length
+func (bytePacketBuffer *BytePacketBuffer) writeCharacterString(text string) error {
+	if len(text) > 255 {
+		return InvalidInput("Character string exceeds 255 bytes of length")
+	}
+
+	startPos := bytePacketBuffer.pos
+	if err := bytePacketBuffer.write(byte(len(text))); err != nil {
 		return err
 	}
 
+	for _, b := range []byte(text) {
+		if err := bytePacketBuffer.write(b); err != nil {
+			bytePacketBuffer.pos = startPos
+			return err
+		}
+	}
+
 	return nil
 }
 
+// grow extends the underlying slice so that it holds at least size bytes
+func (bytePacketBuffer *BytePacketBuffer) grow(size uint32) {
+	for bytePacketBuffer.Len() < size {
+		bytePacketBuffer.buf = append(bytePacketBuffer.buf, 0)
+	}
+}
+
 // Set writes a byte at the specified position
 func (bytePacketBuffer *BytePacketBuffer) Set(pos uint32, val byte) error {
+	if pos >= bytePacketBuffer.Len() {
+		return InvalidInput(fmt.Sprintf("End of buffer. Failed at position %d.", pos))
+	}
+
 	bytePacketBuffer.buf[pos] = val
 	return nil
 }
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maxCacheEntries bounds how many RRsets the cache holds at once
+const maxCacheEntries = 10000
+
+// Cache holds RRsets learned from upstream servers until their TTL runs out,
+// along with negative answers for names and types that do not exist.
+type Cache struct {
+	mutex     sync.Mutex
+	entries   map[cacheKey]cacheEntry
+	negatives map[cacheKey]negativeEntry
+}
+
+type cacheKey struct {
+	name  string
+	qtype QueryType
+	class Class
+	// covered is the type an RRSIG set signs, so each RRset keeps its own
+	covered QueryType
+}
+
+type cacheEntry struct {
+	records []Record
+	expires time.Time
+}
+
+// negativeEntry remembers an NXDOMAIN or NODATA answer together with the
+// authority records, including the SOA, that came with it
+type negativeEntry struct {
+	rescode     ResultCode
+	authorities []Record
+	expires     time.Time
+}
+
+// NewCache creates an empty cache
+func NewCache() *Cache {
+	return &Cache{entries: make(map[cacheKey]cacheEntry), negatives: make(map[cacheKey]negativeEntry)}
+}
+
+func newCacheKey(name string, qtype QueryType, class Class) cacheKey {
+	return cacheKey{normalizeName(name), qtype, class, UNKNOWN}
+}
+
+// rrsetKey returns the key of the RRset record belongs to. Signatures are
+// keyed by the type they cover so they can be served alongside that RRset.
+func rrsetKey(record Record) cacheKey {
+	header := record.Header()
+	key := newCacheKey(header.domain, header.qtype, header.class)
+	if rrsigRecord, ok := record.(RrsigRecord); ok {
+		key.covered = rrsigRecord.typeCovered
+	}
+
+	return key
+}
+
+// Put stores a single RRset. The whole set expires with its lowest TTL.
+func (cache *Cache) Put(records []Record) {
+	if len(records) == 0 {
+		return
+	}
+
+	header := records[0].Header()
+	if header.qtype == OPT {
+		return
+	}
+
+	ttl := header.ttl
+	for _, record := range records {
+		if record.Header().ttl < ttl {
+			ttl = record.Header().ttl
+		}
+	}
+	if ttl == 0 {
+		return
+	}
+
+	stored := make([]Record, len(records))
+	copy(stored, records)
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if len(cache.entries) >= maxCacheEntries {
+		cache.purge()
+		if len(cache.entries) >= maxCacheEntries {
+			return
+		}
+	}
+
+	cache.entries[rrsetKey(records[0])] = cacheEntry{stored, time.Now().Add(time.Duration(ttl) * time.Second)}
+}
+
+// PutRecords splits records into RRsets and stores each of them
+func (cache *Cache) PutRecords(records []Record) {
+	for _, rrset := range groupRRsets(records) {
+		cache.Put(rrset)
+	}
+}
+
+// Get returns a cached RRset followed by any RRSIGs covering it, with each
+// TTL counted down by the time it has spent in the cache.
+func (cache *Cache) Get(name string, qtype QueryType, class Class) ([]Record, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	records, ok := cache.get(newCacheKey(name, qtype, class))
+	if !ok {
+		return nil, false
+	}
+
+	sigKey := newCacheKey(name, RRSIG, class)
+	sigKey.covered = qtype
+	sigs, _ := cache.get(sigKey)
+
+	return append(records, sigs...), true
+}
+
+// Remove drops the RRset of qtype at name and its signatures, so the next
+// lookup goes upstream
+func (cache *Cache) Remove(name string, qtype QueryType, class Class) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	key := newCacheKey(name, qtype, class)
+	delete(cache.entries, key)
+	delete(cache.negatives, key)
+
+	sigKey := newCacheKey(name, RRSIG, class)
+	sigKey.covered = qtype
+	delete(cache.entries, sigKey)
+}
+
+// get returns the live entry for key. The caller must hold the mutex.
+func (cache *Cache) get(key cacheKey) ([]Record, bool) {
+	entry, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	remaining := time.Until(entry.expires)
+	if remaining <= 0 {
+		delete(cache.entries, key)
+		return nil, false
+	}
+
+	records := make([]Record, len(entry.records))
+	for idx, record := range entry.records {
+		records[idx] = record.WithTTL(uint32(remaining / time.Second))
+	}
+
+	return records, true
+}
+
+// PutNegative remembers that qname does not exist (NXDOMAIN) or has no
+// records of qtype (NODATA). Following RFC 2308 the answer lives for the
+// lesser of the SOA's TTL and its MINIMUM field, and is not cached at all
+// without an SOA. An NXDOMAIN covers every type at qname.
+func (cache *Cache) PutNegative(qname string, qtype QueryType, class Class, rescode ResultCode, authorities []Record) {
+	ttl, ok := negativeTTL(authorities)
+	if !ok || ttl == 0 {
+		return
+	}
+
+	if rescode == NXDOMAIN {
+		qtype = UNKNOWN
+	}
+
+	stored := make([]Record, len(authorities))
+	copy(stored, authorities)
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if len(cache.negatives) >= maxCacheEntries {
+		cache.purge()
+		if len(cache.negatives) >= maxCacheEntries {
+			return
+		}
+	}
+
+	key := newCacheKey(qname, qtype, class)
+	cache.negatives[key] = negativeEntry{rescode, stored, time.Now().Add(time.Duration(ttl) * time.Second)}
+}
+
+// GetNegative returns a cached NXDOMAIN or NODATA answer for qname and qtype,
+// with the authority TTLs counted down.
+func (cache *Cache) GetNegative(qname string, qtype QueryType, class Class) (ResultCode, []Record, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for _, key := range []cacheKey{newCacheKey(qname, UNKNOWN, class), newCacheKey(qname, qtype, class)} {
+		entry, ok := cache.negatives[key]
+		if !ok {
+			continue
+		}
+
+		remaining := time.Until(entry.expires)
+		if remaining <= 0 {
+			delete(cache.negatives, key)
+			continue
+		}
+
+		authorities := make([]Record, len(entry.authorities))
+		for idx, record := range entry.authorities {
+			authorities[idx] = record.WithTTL(uint32(remaining / time.Second))
+		}
+
+		return entry.rescode, authorities, true
+	}
+
+	return NOERROR, nil, false
+}
+
+// GetDname returns a cached DNAME record owned by a proper ancestor of qname
+func (cache *Cache) GetDname(qname string) (DnameRecord, bool) {
+	name := normalizeName(qname)
+	for len(name) > 0 {
+		name = parentName(name)
+		if records, ok := cache.Get(name, DNAME, IN); ok {
+			if dnameRecord, ok := records[0].(DnameRecord); ok {
+				return dnameRecord, true
+			}
+		}
+	}
+
+	return DnameRecord{}, false
+}
+
+// GetNameservers finds the closest zone cut above qname with cached NS
+// records and returns that zone with the cached addresses of its servers.
+func (cache *Cache) GetNameservers(qname string) (string, []string) {
+	zone := normalizeName(qname)
+	for {
+		if nsRecords, ok := cache.Get(zone, NS, IN); ok {
+			addrs := []string{}
+			for _, record := range nsRecords {
+				nsRecord, ok := record.(NsRecord)
+				if !ok {
+					continue
+				}
+
+				glue, _ := cache.Get(nsRecord.host, A, IN)
+				for _, record := range glue {
+					if aRecord, ok := record.(ARecord); ok {
+						addrs = append(addrs, aRecord.addr.String())
+					}
+				}
+			}
+
+			if len(addrs) > 0 {
+				return zone, addrs
+			}
+		}
+
+		if len(zone) == 0 {
+			return "", nil
+		}
+		zone = parentName(zone)
+	}
+}
+
+// purge removes expired entries. The caller must hold the mutex.
+func (cache *Cache) purge() {
+	now := time.Now()
+	for key, entry := range cache.entries {
+		if now.After(entry.expires) {
+			delete(cache.entries, key)
+		}
+	}
+
+	for key, entry := range cache.negatives {
+		if now.After(entry.expires) {
+			delete(cache.negatives, key)
+		}
+	}
+}
+
+// negativeTTL returns how long a negative answer may be cached, based on the
+// SOA record in its authority section
+func negativeTTL(authorities []Record) (uint32, bool) {
+	for _, record := range authorities {
+		if soaRecord, ok := record.(SoaRecord); ok {
+			return min(soaRecord.ttl, soaRecord.minimum), true
+		}
+	}
+
+	return 0, false
+}
+
+// groupRRsets splits records into RRsets, keeping the order in which each
+// set first appears.
+func groupRRsets(records []Record) [][]Record {
+	rrsets := [][]Record{}
+	for _, record := range records {
+		found := false
+		for idx, rrset := range rrsets {
+			if rrsetKey(rrset[0]) == rrsetKey(record) {
+				rrsets[idx] = append(rrset, record)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			rrsets = append(rrsets, []Record{record})
+		}
+	}
+
+	return rrsets
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Class represents the class of a DNS record or question
+type Class uint16
+
+// Class enumerations
+const (
+	IN   Class = 1
+	CH   Class = 3
+	HS   Class = 4
+	NONE Class = 254
+	ANY  Class = 255
+)
+
+func (class Class) String() string {
+	switch class {
+	case IN:
+		return "IN"
+	case CH:
+		return "CH"
+	case HS:
+		return "HS"
+	case NONE:
+		return "NONE"
+	case ANY:
+		return "ANY"
+	default:
+		return fmt.Sprintf("CLASS%d", uint16(class))
+	}
+}
+
+// parseClass reads a class mnemonic as zone files write it, including the
+// generic CLASSnnn form (RFC 3597 section 5)
+func parseClass(text string) (Class, bool) {
+	text = strings.ToUpper(text)
+	for _, class := range []Class{IN, CH, HS, NONE, ANY} {
+		if class.String() == text {
+			return class, true
+		}
+	}
+
+	if number, ok := strings.CutPrefix(text, "CLASS"); ok {
+		if value, err := strconv.ParseUint(number, 10, 16); err == nil {
+			return Class(value), true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"strings"
+)
+
+const (
+	// nsec3SHA1 is the only NSEC3 hash algorithm defined (RFC 5155 section 11)
+	nsec3SHA1 = 1
+	// maxNsec3Iterations is the most NSEC3 hash iterations the validator will
+	// compute. Proofs needing more are treated as insecure (RFC 9276 section 3.2).
+	maxNsec3Iterations = 150
+)
+
+// validateDenial works out the security status of a negative answer for
+// qname and qtype from the SOA, NSEC and NSEC3 records in its authority
+// section. Once their signatures check out they still have to prove the
+// name or type really is missing.
+func validateDenial(qname string, qtype QueryType, rescode ResultCode, authorities []Record) SecurityStatus {
+	denial := []Record{}
+	for _, record := range authorities {
+		switch record.Header().qtype {
+		case SOA, NSEC, NSEC3, RRSIG:
+			denial = append(denial, record)
+		}
+	}
+
+	if len(denial) == 0 {
+		if unsignedStatus(qname) == Insecure {
+			return Insecure
+		}
+		return Bogus
+	}
+
+	if status := validateRRsets(denial); status != Secure {
+		return status
+	}
+
+	return proveDenial(qname, qtype, rescode, denial)
+}
+
+// proveDenial checks that the NSEC or NSEC3 records among authorities show
+// qname does not exist (NXDOMAIN) or has no records of qtype (NODATA).
+// A proof that rests on an NSEC3 opt-out span only shows the answer is insecure.
+func proveDenial(qname string, qtype QueryType, rescode ResultCode, authorities []Record) SecurityStatus {
+	qname = normalizeName(qname)
+	nsecs, nsec3s := denialRecords(qname, authorities)
+
+	if len(nsecs) > 0 {
+		if rescode == NXDOMAIN && proveNsecNxdomain(qname, nsecs) {
+			return Secure
+		}
+		if rescode == NOERROR && proveNsecNodata(qname, qtype, nsecs) {
+			return Secure
+		}
+	}
+
+	if len(nsec3s) > 0 {
+		if rescode == NXDOMAIN {
+			return proveNsec3Nxdomain(qname, nsec3s)
+		}
+		return proveNsec3Nodata(qname, qtype, nsec3s)
+	}
+
+	return Bogus
+}
+
+// proveWildcard checks that an answer synthesized from a wildcard was the
+// right one to give: the signature's label count says which wildcard was
+// expanded, and NSEC or NSEC3 records must show that no closer name exists.
+func proveWildcard(owner string, labels uint8, authorities []Record) SecurityStatus {
+	owner = normalizeName(owner)
+	nsecs, nsec3s := denialRecords(owner, authorities)
+	for _, nsec := range nsecs {
+		if nsecProvesAbsent(nsec, owner) {
+			return Secure
+		}
+	}
+
+	if len(nsec3s) == 0 {
+		return Bogus
+	}
+
+	if !usableNsec3(nsec3s) {
+		return Insecure
+	}
+
+	ownerLabels := strings.Split(owner, ".")
+	nextCloser := strings.Join(ownerLabels[len(ownerLabels)-int(labels)-1:], ".")
+	if covering, ok := nsec3Covering(nextCloser, nsec3s); ok {
+		if covering.OptOut() {
+			return Insecure
+		}
+		return Secure
+	}
+
+	return Bogus
+}
+
+// denialRecords picks the NSEC and NSEC3 records out of authorities that are
+// signed by a zone qname lies in. Records from any other zone prove nothing
+// about qname, however well signed they are.
+func denialRecords(qname string, authorities []Record) ([]NsecRecord, []Nsec3Record) {
+	signers := []string{}
+	for _, record := range authorities {
+		rrsigRecord, ok := record.(RrsigRecord)
+		if !ok || (rrsigRecord.typeCovered != NSEC && rrsigRecord.typeCovered != NSEC3) {
+			continue
+		}
+
+		if isSubdomain(qname, rrsigRecord.signerName) && isSubdomain(rrsigRecord.domain, rrsigRecord.signerName) {
+			signers = append(signers, normalizeName(rrsigRecord.domain))
+		}
+	}
+
+	signed := func(name string) bool {
+		for _, signer := range signers {
+			if signer == normalizeName(name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	nsecs := []NsecRecord{}
+	nsec3s := []Nsec3Record{}
+	for _, record := range authorities {
+		switch record := record.(type) {
+		case NsecRecord:
+			if signed(record.domain) {
+				nsecs = append(nsecs, record)
+			}
+		case Nsec3Record:
+			if signed(record.domain) && isSubdomain(qname, parentName(normalizeName(record.domain))) {
+				nsec3s = append(nsec3s, record)
+			}
+		}
+	}
+
+	return nsecs, nsec3s
+}
+
+// nsecCovers reports whether name falls strictly between the owner of nsec
+// and the next name, in canonical order
+func nsecCovers(nsec NsecRecord, name string) bool {
+	if compareNames(nsec.domain, nsec.nextDomain) < 0 {
+		return compareNames(nsec.domain, name) < 0 && compareNames(name, nsec.nextDomain) < 0
+	}
+
+	// The last NSEC in a zone points back to the apex
+	return compareNames(nsec.domain, name) < 0
+}
+
+// nsecProvesAbsent reports whether nsec shows that name does not exist. An
+// NSEC at a delegation or DNAME says nothing about the names beneath it.
+func nsecProvesAbsent(nsec NsecRecord, name string) bool {
+	if !nsecCovers(nsec, name) {
+		return false
+	}
+
+	if isSubdomain(name, nsec.domain) {
+		if hasType(nsec.types, DNAME) || (hasType(nsec.types, NS) && !hasType(nsec.types, SOA)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// nsecClosestEncloser derives the closest encloser of name from the NSEC
+// that covers it: the deepest existing ancestor is shared with either the
+// owner or the next name of that NSEC
+func nsecClosestEncloser(nsec NsecRecord, name string) string {
+	owner := commonAncestor(name, nsec.domain)
+	next := commonAncestor(name, nsec.nextDomain)
+	if labelCount(next) > labelCount(owner) {
+		return next
+	}
+
+	return owner
+}
+
+// wildcardName returns the wildcard name directly beneath closest encloser ce
+func wildcardName(ce string) string {
+	if len(ce) == 0 {
+		return "*"
+	}
+
+	return "*." + ce
+}
+
+// proveNsecNxdomain needs an NSEC covering qname and one covering the
+// wildcard at its closest encloser (RFC 4035 section 5.4)
+func proveNsecNxdomain(qname string, nsecs []NsecRecord) bool {
+	for _, nsec := range nsecs {
+		if !nsecProvesAbsent(nsec, qname) {
+			continue
+		}
+
+		wildcard := wildcardName(nsecClosestEncloser(nsec, qname))
+		for _, other := range nsecs {
+			if nsecProvesAbsent(other, wildcard) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// proveNsecNodata needs an NSEC at qname without qtype or CNAME in its
+// bitmap, an NSEC showing qname is an empty non-terminal, or proof that
+// qname does not exist alongside an NSEC at the wildcard that would have
+// matched it, likewise without qtype
+func proveNsecNodata(qname string, qtype QueryType, nsecs []NsecRecord) bool {
+	for _, nsec := range nsecs {
+		if normalizeName(nsec.domain) != qname {
+			continue
+		}
+
+		if hasType(nsec.types, qtype) || hasType(nsec.types, CNAME) {
+			return false
+		}
+
+		// The parent side of a delegation only speaks for DS, the child
+		// side for everything but DS
+		if qtype == DS {
+			return !hasType(nsec.types, SOA)
+		}
+		return !hasType(nsec.types, NS) || hasType(nsec.types, SOA)
+	}
+
+	// An empty non-terminal has no NSEC of its own, but the NSEC covering it
+	// leads on to a name beneath it (RFC 4035 section 3.1.3.2)
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, qname) && isSubdomain(nsec.nextDomain, qname) && normalizeName(nsec.nextDomain) != qname {
+			return true
+		}
+	}
+
+	for _, nsec := range nsecs {
+		if !nsecProvesAbsent(nsec, qname) {
+			continue
+		}
+
+		wildcard := wildcardName(nsecClosestEncloser(nsec, qname))
+		for _, other := range nsecs {
+			if normalizeName(other.domain) == wildcard && !hasType(other.types, qtype) && !hasType(other.types, CNAME) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// usableNsec3 reports whether the validator can work with these NSEC3
+// records at all: a known hash and an iteration count it is willing to pay for
+func usableNsec3(nsec3s []Nsec3Record) bool {
+	for _, nsec3 := range nsec3s {
+		if nsec3.hashAlgorithm != nsec3SHA1 || nsec3.iterations > maxNsec3Iterations {
+			return false
+		}
+	}
+
+	return true
+}
+
+// nsec3Hash hashes a name the way NSEC3 owner names are formed: SHA-1 over
+// the canonical wire name and salt, then again over each digest and the salt
+// for the given number of extra iterations (RFC 5155 section 5)
+func nsec3Hash(name string, salt []byte, iterations uint16) []byte {
+	buffer := newCanonicalBuffer()
+	buffer.writeUncompressedQName(name)
+
+	digest := sha1.Sum(append(buffer.Bytes(), salt...))
+	for idx := 0; idx < int(iterations); idx++ {
+		digest = sha1.Sum(append(digest[:], salt...))
+	}
+
+	return digest[:]
+}
+
+// ownerHash decodes the hash that forms the first label of an NSEC3 owner
+func (record Nsec3Record) ownerHash() []byte {
+	label, _, _ := strings.Cut(normalizeName(record.domain), ".")
+	hashed, err := base32Hex.DecodeString(strings.ToUpper(label))
+	if err != nil {
+		return nil
+	}
+
+	return hashed
+}
+
+// nsec3Matches reports whether nsec3 is the NSEC3 record of name itself
+func nsec3Matches(nsec3 Nsec3Record, name string) bool {
+	ownerHash := nsec3.ownerHash()
+	return ownerHash != nil && bytes.Equal(ownerHash, nsec3Hash(name, nsec3.salt, nsec3.iterations))
+}
+
+// nsec3Covers reports whether the hash of name falls strictly between the
+// owner hash of nsec3 and its next hashed owner
+func nsec3Covers(nsec3 Nsec3Record, name string) bool {
+	ownerHash := nsec3.ownerHash()
+	if ownerHash == nil {
+		return false
+	}
+
+	hashed := nsec3Hash(name, nsec3.salt, nsec3.iterations)
+	if bytes.Compare(ownerHash, nsec3.nextHashed) < 0 {
+		return bytes.Compare(ownerHash, hashed) < 0 && bytes.Compare(hashed, nsec3.nextHashed) < 0
+	}
+
+	// The last NSEC3 in the hash order wraps round to the first
+	return bytes.Compare(ownerHash, hashed) < 0 || bytes.Compare(hashed, nsec3.nextHashed) < 0
+}
+
+func nsec3Matching(name string, nsec3s []Nsec3Record) (Nsec3Record, bool) {
+	for _, nsec3 := range nsec3s {
+		if nsec3Matches(nsec3, name) {
+			return nsec3, true
+		}
+	}
+
+	return Nsec3Record{}, false
+}
+
+func nsec3Covering(name string, nsec3s []Nsec3Record) (Nsec3Record, bool) {
+	for _, nsec3 := range nsec3s {
+		if nsec3Covers(nsec3, name) {
+			return nsec3, true
+		}
+	}
+
+	return Nsec3Record{}, false
+}
+
+// nsec3ClosestEncloser runs the closest encloser proof of RFC 5155 section
+// 8.3: the deepest ancestor of qname with a matching NSEC3, together with the
+// NSEC3 covering the next closer name one label further down. A match at a
+// delegation or DNAME does not count, since names beneath those are not in
+// the zone.
+func nsec3ClosestEncloser(qname string, nsec3s []Nsec3Record) (string, Nsec3Record, bool) {
+	zone := parentName(normalizeName(nsec3s[0].domain))
+	labels := strings.Split(qname, ".")
+	for idx := 1; idx <= len(labels); idx++ {
+		ce := strings.Join(labels[idx:], ".")
+		if !isSubdomain(ce, zone) {
+			break
+		}
+
+		matching, ok := nsec3Matching(ce, nsec3s)
+		if !ok {
+			continue
+		}
+
+		if hasType(matching.types, DNAME) || (hasType(matching.types, NS) && !hasType(matching.types, SOA)) {
+			return "", Nsec3Record{}, false
+		}
+
+		nextCloser := strings.Join(labels[idx-1:], ".")
+		covering, ok := nsec3Covering(nextCloser, nsec3s)
+		return ce, covering, ok
+	}
+
+	return "", Nsec3Record{}, false
+}
+
+// proveNsec3Nxdomain needs a closest encloser proof for qname and an NSEC3
+// covering the wildcard at the closest encloser (RFC 5155 section 8.4)
+func proveNsec3Nxdomain(qname string, nsec3s []Nsec3Record) SecurityStatus {
+	if !usableNsec3(nsec3s) {
+		return Insecure
+	}
+
+	ce, nextCloser, ok := nsec3ClosestEncloser(qname, nsec3s)
+	if !ok {
+		return Bogus
+	}
+
+	if _, ok := nsec3Covering(wildcardName(ce), nsec3s); !ok {
+		return Bogus
+	}
+
+	// An opt-out span may hide an unsigned delegation, so qname might exist
+	if nextCloser.OptOut() {
+		return Insecure
+	}
+
+	return Secure
+}
+
+// proveNsec3Nodata needs an NSEC3 matching qname without qtype or CNAME in
+// its bitmap. Failing that, a DS query may be answered by an opt-out span
+// covering the next closer name, and a wildcard match by a closest encloser
+// proof plus an NSEC3 for the wildcard without qtype (RFC 5155 sections
+// 8.5 to 8.7).
+func proveNsec3Nodata(qname string, qtype QueryType, nsec3s []Nsec3Record) SecurityStatus {
+	if !usableNsec3(nsec3s) {
+		return Insecure
+	}
+
+	if matching, ok := nsec3Matching(qname, nsec3s); ok {
+		if hasType(matching.types, qtype) || hasType(matching.types, CNAME) {
+			return Bogus
+		}
+
+		delegation := hasType(matching.types, NS) && !hasType(matching.types, SOA)
+		if (qtype == DS && hasType(matching.types, SOA)) || (qtype != DS && delegation) {
+			return Bogus
+		}
+		return Secure
+	}
+
+	ce, nextCloser, ok := nsec3ClosestEncloser(qname, nsec3s)
+	if !ok {
+		return Bogus
+	}
+
+	if qtype == DS && nextCloser.OptOut() {
+		return Insecure
+	}
+
+	if wildcard, ok := nsec3Matching(wildcardName(ce), nsec3s); ok {
+		if !hasType(wildcard.types, qtype) && !hasType(wildcard.types, CNAME) {
+			return Secure
+		}
+	}
+
+	return Bogus
+}
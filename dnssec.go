@@ -0,0 +1,860 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSSEC algorithm numbers the validator supports
+const (
+	RSASHA256       = 8
+	ECDSAP256SHA256 = 13
+	ED25519         = 15
+)
+
+// DS digest types the validator supports
+const (
+	digestSHA256 = 2
+	digestSHA384 = 4
+)
+
+const (
+	// maxKeyTTL caps how long a zone's validated keys are trusted before the
+	// chain of trust to them is checked again
+	maxKeyTTL = 3600
+	// bogusKeyTTL is how long a zone whose keys failed to validate is
+	// remembered before the resolver tries again
+	bogusKeyTTL = 60
+)
+
+// SecurityStatus is the outcome of validating a response (RFC 4035 section 4.3)
+type SecurityStatus int
+
+const (
+	// Insecure data lies below a proven unsigned delegation, or no trust anchor covers it
+	Insecure SecurityStatus = iota
+	// Secure data has a chain of trust from a trust anchor
+	Secure
+	// Bogus data should be signed but the signatures are missing or wrong
+	Bogus
+)
+
+func (status SecurityStatus) String() string {
+	switch status {
+	case Secure:
+		return "secure"
+	case Bogus:
+		return "bogus"
+	default:
+		return "insecure"
+	}
+}
+
+// ValidationError explains why a signature did not verify
+type ValidationError string
+
+func (e ValidationError) Error() string {
+	return string(e)
+}
+
+// trustAnchors are the DS records chains of trust start from, by default the
+// root zone's key signing keys as published by IANA
+var trustAnchors = []DsRecord{
+	{domain: "", keyTag: 20326, algorithm: RSASHA256, digestType: digestSHA256, digest: mustDecodeHex("E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"), class: IN},
+	{domain: "", keyTag: 38696, algorithm: RSASHA256, digestType: digestSHA256, digest: mustDecodeHex("683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16"), class: IN},
+}
+
+func mustDecodeHex(data string) []byte {
+	decoded, err := hex.DecodeString(data)
+	if err != nil {
+		panic(err)
+	}
+
+	return decoded
+}
+
+// loadTrustAnchors reads DS records in zone file format, one per line, such
+// as ". IN DS 20326 8 2 E06D44B8...". Blank lines and ; comments are skipped.
+func loadTrustAnchors(path string) ([]DsRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	anchors := []DsRecord{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), ";")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		typeIdx := -1
+		for idx, field := range fields {
+			if strings.EqualFold(field, "DS") {
+				typeIdx = idx
+				break
+			}
+		}
+		if typeIdx < 1 || len(fields) < typeIdx+5 {
+			return nil, InvalidInput(fmt.Sprintf("Invalid trust anchor: %s", line))
+		}
+
+		var values [3]uint64
+		for idx, bits := range []int{16, 8, 8} {
+			values[idx], err = strconv.ParseUint(fields[typeIdx+1+idx], 10, bits)
+			if err != nil {
+				return nil, InvalidInput(fmt.Sprintf("Invalid trust anchor: %s", line))
+			}
+		}
+
+		digest, err := hex.DecodeString(strings.Join(fields[typeIdx+4:], ""))
+		if err != nil {
+			return nil, InvalidInput(fmt.Sprintf("Invalid trust anchor digest: %s", line))
+		}
+
+		anchors = append(anchors, DsRecord{domain: normalizeName(fields[0]), keyTag: uint16(values[0]), algorithm: uint8(values[1]),
+			digestType: uint8(values[2]), digest: digest, class: IN})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(anchors) == 0 {
+		return nil, InvalidInput(fmt.Sprintf("No trust anchors in %s", path))
+	}
+
+	return anchors, nil
+}
+
+// zoneKeys is the validated outcome for a zone's DNSKEY RRset
+type zoneKeys struct {
+	keys    []DnskeyRecord
+	status  SecurityStatus
+	expires time.Time
+}
+
+var (
+	keysMutex sync.Mutex
+	// validatedKeys remembers each zone's keys once the chain of trust to them is checked
+	validatedKeys = make(map[string]zoneKeys)
+)
+
+// validateResponse works out the security status of a response from
+// recursiveLookup. Positive answers are checked RRset by RRset, and whatever
+// the answer lacks, be it the name at the end of a CNAME chain or the type
+// asked for, has to be proven missing by the authority section. Data from
+// our own zones is taken as it is and counts as insecure.
+func validateResponse(question Question, response Packet) SecurityStatus {
+	rescode := response.header.rescode
+	if rescode != NOERROR && rescode != NXDOMAIN {
+		return Insecure
+	}
+
+	status := Secure
+	name := normalizeName(question.name)
+	if len(response.answers) > 0 {
+		status = validateRRsets(withoutSynthesized(response.answers))
+		if status == Bogus {
+			return Bogus
+		}
+
+		// A signature with fewer labels than its owner was made over a wildcard
+		for _, record := range response.answers {
+			if rrsigRecord, ok := record.(RrsigRecord); ok && int(rrsigRecord.labels) < labelCount(rrsigRecord.domain) &&
+				!servedLocally(rrsigRecord.domain, rrsigRecord.typeCovered) {
+				status = weakestStatus(status, proveWildcard(rrsigRecord.domain, rrsigRecord.labels, response.authorities))
+			}
+		}
+
+		for range maxChainLength {
+			cnameRecord, ok := response.getCName(name)
+			if !ok {
+				break
+			}
+			name = normalizeName(cnameRecord.host)
+		}
+
+		if question.qType == CNAME || response.hasRRset(name, question.qType) {
+			return status
+		}
+	}
+
+	if servedLocally(name, question.qType) {
+		return weakestStatus(status, Insecure)
+	}
+
+	return weakestStatus(status, validateDenial(name, question.qType, rescode, response.authorities))
+}
+
+// weakestStatus returns whichever of two statuses is less trustworthy
+func weakestStatus(a SecurityStatus, b SecurityStatus) SecurityStatus {
+	if a == Bogus || b == Bogus {
+		return Bogus
+	}
+
+	if a == Insecure || b == Insecure {
+		return Insecure
+	}
+
+	return Secure
+}
+
+// withoutSynthesized drops the CNAMEs a server synthesized from a DNAME in the
+// same answer. Those are never signed; the DNAME vouches for them.
+func withoutSynthesized(answers []Record) []Record {
+	records := make([]Record, 0, len(answers))
+	for _, record := range answers {
+		if cnameRecord, ok := record.(CNameRecord); ok {
+			synthesized := false
+			for _, other := range answers {
+				dnameRecord, ok := other.(DnameRecord)
+				if !ok {
+					continue
+				}
+
+				if target, ok := dnameRecord.Substitute(cnameRecord.domain); ok && normalizeName(target) == normalizeName(cnameRecord.host) {
+					synthesized = true
+					break
+				}
+			}
+
+			if synthesized {
+				continue
+			}
+		}
+
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// validateRRsets validates every RRset in records against the RRSIGs among
+// them. A single bogus RRset makes the whole lot bogus, and a single
+// insecure one makes it insecure.
+func validateRRsets(records []Record) SecurityStatus {
+	status := Secure
+	validated := false
+	for _, rrset := range groupRRsets(records) {
+		header := rrset[0].Header()
+		if header.qtype == RRSIG {
+			continue
+		}
+
+		validated = true
+		if servedLocally(header.domain, header.qtype) {
+			status = Insecure
+			continue
+		}
+
+		sigs := []RrsigRecord{}
+		for _, record := range records {
+			if rrsigRecord, ok := record.(RrsigRecord); ok && rrsigRecord.typeCovered == header.qtype &&
+				rrsigRecord.class == header.class && strings.EqualFold(rrsigRecord.domain, header.domain) {
+				sigs = append(sigs, rrsigRecord)
+			}
+		}
+
+		switch validateRRset(rrset, sigs) {
+		case Bogus:
+			fmt.Printf("Bogus %s RRset for %s\n", header.qtype, header.domain)
+			return Bogus
+		case Insecure:
+			status = Insecure
+		}
+	}
+
+	if !validated {
+		return Insecure
+	}
+
+	return status
+}
+
+// validateRRset checks rrset against its signatures. An unsigned RRset is
+// only acceptable when the zone it comes from is insecure.
+func validateRRset(rrset []Record, sigs []RrsigRecord) SecurityStatus {
+	owner := rrset[0].Header().domain
+	if len(sigs) == 0 {
+		status := unsignedStatus(owner)
+		if status == Secure {
+			return Bogus
+		}
+		return status
+	}
+
+	status := Bogus
+	for _, sig := range sigs {
+		if !isSubdomain(owner, sig.signerName) {
+			continue
+		}
+
+		keys, keyStatus := getZoneKeys(sig.signerName)
+		if keyStatus != Secure {
+			status = keyStatus
+			continue
+		}
+
+		if verifyWithKeys(sig, rrset, keys) == nil {
+			return Secure
+		}
+	}
+
+	return status
+}
+
+// clampToSignatures caps the TTL of each signed RRset in records at the
+// original TTL of its RRSIGs and at the time they have left before expiring,
+// which is as long a validated RRset may be kept (RFC 4035 section 5.3.3)
+func clampToSignatures(records []Record) []Record {
+	now := uint32(time.Now().Unix())
+	clamped := make([]Record, len(records))
+	for idx, record := range records {
+		header := record.Header()
+		covered := header.qtype
+		if rrsigRecord, ok := record.(RrsigRecord); ok {
+			covered = rrsigRecord.typeCovered
+		}
+
+		ttl := header.ttl
+		for _, other := range records {
+			sig, ok := other.(RrsigRecord)
+			if !ok || sig.typeCovered != covered || sig.class != header.class || !strings.EqualFold(normalizeName(sig.domain), normalizeName(header.domain)) {
+				continue
+			}
+
+			remaining := uint32(0)
+			if int32(sig.expiration-now) > 0 {
+				remaining = sig.expiration - now
+			}
+			ttl = min(ttl, sig.originalTTL, remaining)
+		}
+
+		clamped[idx] = record
+		if ttl != header.ttl {
+			clamped[idx] = record.WithTTL(ttl)
+		}
+	}
+
+	return clamped
+}
+
+// unsignedStatus reports whether the zone holding name is signed, which is
+// what decides if unsigned data from it is insecure or bogus
+func unsignedStatus(name string) SecurityStatus {
+	zone, err := findZone(name)
+	if err != nil {
+		fmt.Printf("Failed to find the zone of %s: %s\n", name, err)
+		return Bogus
+	}
+
+	_, status := getZoneKeys(zone)
+	return status
+}
+
+// findZone returns the apex of the zone name lies in, going by the SOA record
+// that turns up in the answer or authority section of a query for its SOA
+func findZone(name string) (string, error) {
+	name = normalizeName(name)
+	for len(name) > 0 {
+		response, err := resolveName(name, SOA)
+		if err != nil {
+			return "", err
+		}
+
+		for _, section := range [][]Record{response.answers, response.authorities} {
+			for _, record := range section {
+				if soaRecord, ok := record.(SoaRecord); ok && isSubdomain(name, soaRecord.domain) {
+					return normalizeName(soaRecord.domain), nil
+				}
+			}
+		}
+
+		// Without an SOA, name is not an apex, so it shares its parent's zone
+		name = parentName(name)
+	}
+
+	return "", nil
+}
+
+// getZoneKeys returns the keys of zone the chain of trust vouches for,
+// validating them first if they are not already known.
+func getZoneKeys(zone string) ([]DnskeyRecord, SecurityStatus) {
+	zone = normalizeName(zone)
+
+	keysMutex.Lock()
+	entry, ok := validatedKeys[zone]
+	keysMutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.keys, entry.status
+	}
+
+	return storeZoneKeys(zone)
+}
+
+// refreshZoneKeys fetches and validates the DNSKEY RRset of zone afresh,
+// whatever the cache still holds for it
+func refreshZoneKeys(zone string) ([]DnskeyRecord, SecurityStatus) {
+	zone = normalizeName(zone)
+	cache.Remove(zone, DNSKEY, IN)
+	return storeZoneKeys(zone)
+}
+
+// storeZoneKeys builds the keys of zone and remembers them until their TTL runs out
+func storeZoneKeys(zone string) ([]DnskeyRecord, SecurityStatus) {
+	keys, status, ttl := buildZoneKeys(zone)
+	if status == Bogus {
+		ttl = min(ttl, bogusKeyTTL)
+	}
+
+	keysMutex.Lock()
+	validatedKeys[zone] = zoneKeys{keys, status, time.Now().Add(time.Duration(ttl) * time.Second)}
+	keysMutex.Unlock()
+
+	return keys, status
+}
+
+// buildZoneKeys walks the chain of trust down to zone: the parent's keys must
+// be secure, the DS RRset for zone must be signed by them, and the DNSKEY
+// RRset must be signed by a key one of those DS records matches. It returns
+// the zone's keys, their status and how long the result holds.
+func buildZoneKeys(zone string) ([]DnskeyRecord, SecurityStatus, uint32) {
+	// Once the store tracks a zone's keys, the configured DS records only
+	// served to bootstrap it
+	managed := anchorStore.Manages(zone)
+	dsRecords := []DsRecord{}
+	if !managed {
+		for _, anchor := range trustAnchors {
+			if normalizeName(anchor.domain) == zone {
+				dsRecords = append(dsRecords, anchor)
+			}
+		}
+	}
+	anchored := managed || len(dsRecords) > 0
+
+	ttl := uint32(maxKeyTTL)
+	if !anchored {
+		if len(zone) == 0 {
+			// Nothing anchors the root, so nothing can be validated
+			return nil, Insecure, ttl
+		}
+
+		parent, err := findZone(parentName(zone))
+		if err != nil {
+			fmt.Printf("Failed to find the parent zone of %s: %s\n", zone, err)
+			return nil, Bogus, ttl
+		}
+
+		parentKeys, status := getZoneKeys(parent)
+		if status != Secure {
+			return nil, status, ttl
+		}
+
+		response, err := resolveName(zone, DS)
+		if err != nil {
+			fmt.Printf("Failed to look up DS for %s: %s\n", zone, err)
+			return nil, Bogus, ttl
+		}
+
+		dsSet, sigs := splitRRset(response.answers, zone, DS)
+		if len(dsSet) == 0 {
+			// Without DS records the delegation is unsigned, once that is proven
+			if response.header.rescode != NOERROR || validateDenial(zone, DS, NOERROR, response.authorities) == Bogus {
+				return nil, Bogus, ttl
+			}
+			return nil, Insecure, ttl
+		}
+
+		if err := verifyAny(sigs, dsSet, parentKeys); err != nil {
+			fmt.Printf("DS RRset for %s does not validate: %s\n", zone, err)
+			return nil, Bogus, ttl
+		}
+
+		for _, record := range dsSet {
+			dsRecords = append(dsRecords, record.(DsRecord))
+			ttl = min(ttl, record.Header().ttl)
+		}
+	}
+
+	// A zone whose DS records all use algorithms we lack is treated as unsigned
+	supported := []DsRecord{}
+	for _, dsRecord := range dsRecords {
+		if supportedAlgorithm(dsRecord.algorithm) && supportedDigest(dsRecord.digestType) {
+			supported = append(supported, dsRecord)
+		}
+	}
+	if len(supported) == 0 && !managed {
+		return nil, Insecure, ttl
+	}
+
+	response, err := resolveName(zone, DNSKEY)
+	if err != nil {
+		fmt.Printf("Failed to look up DNSKEY for %s: %s\n", zone, err)
+		return nil, Bogus, ttl
+	}
+
+	// The keys stay trusted no longer than their signatures allow
+	keySet, sigs := splitRRset(clampToSignatures(response.answers), zone, DNSKEY)
+	trusted := anchorStore.Trusted(zone)
+	keys := []DnskeyRecord{}
+	entryKeys := []DnskeyRecord{}
+	for _, record := range keySet {
+		dnskeyRecord := record.(DnskeyRecord)
+		ttl = min(ttl, dnskeyRecord.ttl)
+		if !dnskeyRecord.IsZoneKey() || dnskeyRecord.IsRevoked() {
+			continue
+		}
+		keys = append(keys, dnskeyRecord)
+
+		for _, dsRecord := range supported {
+			if dsMatches(dsRecord, dnskeyRecord) {
+				entryKeys = append(entryKeys, dnskeyRecord)
+				break
+			}
+		}
+
+		for _, trustedKey := range trusted {
+			if sameKey(trustedKey, dnskeyRecord) {
+				entryKeys = append(entryKeys, dnskeyRecord)
+				break
+			}
+		}
+	}
+
+	if err := verifyAny(sigs, keySet, entryKeys); err != nil {
+		fmt.Printf("DNSKEY RRset for %s does not validate: %s\n", zone, err)
+		return nil, Bogus, ttl
+	}
+
+	if anchored {
+		if err := anchorStore.Observe(zone, keySet, sigs, supported); err != nil {
+			fmt.Printf("Failed to update trust anchors for %s: %s\n", zone, err)
+		}
+	}
+
+	return keys, Secure, ttl
+}
+
+// splitRRset picks the RRset of qtype owned by name out of records, along
+// with the RRSIGs that cover it
+func splitRRset(records []Record, name string, qtype QueryType) ([]Record, []RrsigRecord) {
+	rrset := []Record{}
+	sigs := []RrsigRecord{}
+	for _, record := range records {
+		header := record.Header()
+		if !strings.EqualFold(normalizeName(header.domain), name) {
+			continue
+		}
+
+		if rrsigRecord, ok := record.(RrsigRecord); ok && rrsigRecord.typeCovered == qtype {
+			sigs = append(sigs, rrsigRecord)
+		} else if header.qtype == qtype {
+			rrset = append(rrset, record)
+		}
+	}
+
+	return rrset, sigs
+}
+
+// verifyAny succeeds if any of sigs is a valid signature over rrset by one of keys
+func verifyAny(sigs []RrsigRecord, rrset []Record, keys []DnskeyRecord) error {
+	if len(rrset) == 0 {
+		return ValidationError("Empty RRset")
+	}
+
+	var err error = ValidationError("No signatures")
+	for _, sig := range sigs {
+		if err = verifyWithKeys(sig, rrset, keys); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// verifyWithKeys checks sig over rrset with whichever of keys it names
+func verifyWithKeys(sig RrsigRecord, rrset []Record, keys []DnskeyRecord) error {
+	var err error = ValidationError(fmt.Sprintf("No key with tag %d", sig.keyTag))
+	for _, key := range keys {
+		if key.algorithm != sig.algorithm || key.KeyTag() != sig.keyTag {
+			continue
+		}
+
+		if err = verifyRrsig(sig, rrset, key); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// verifyRrsig checks one signature over rrset against key, following the
+// rules of RFC 4035 section 5.3.1
+func verifyRrsig(sig RrsigRecord, rrset []Record, key DnskeyRecord) error {
+	return checkRrsig(sig, rrset, key, false)
+}
+
+// verifyRevocation succeeds if any of sigs is a valid signature over the
+// DNSKEY RRset by the revoked key itself, which is the only use a revoked key
+// still has (RFC 5011 section 2.1)
+func verifyRevocation(sigs []RrsigRecord, keySet []Record, key DnskeyRecord) error {
+	var err error = ValidationError(fmt.Sprintf("Key %d did not sign its revocation", key.KeyTag()))
+	for _, sig := range sigs {
+		if sig.algorithm != key.algorithm || sig.keyTag != key.KeyTag() {
+			continue
+		}
+
+		if err = checkRrsig(sig, keySet, key, true); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// checkRrsig does the work of verifyRrsig, accepting a revoked key only when
+// allowRevoked is set
+func checkRrsig(sig RrsigRecord, rrset []Record, key DnskeyRecord, allowRevoked bool) error {
+	header := rrset[0].Header()
+	if sig.typeCovered != header.qtype || sig.class != header.class || !strings.EqualFold(normalizeName(sig.domain), normalizeName(header.domain)) {
+		return ValidationError("Signature does not cover this RRset")
+	}
+
+	if normalizeName(sig.signerName) != normalizeName(key.domain) || !isSubdomain(header.domain, sig.signerName) {
+		return ValidationError(fmt.Sprintf("Signer %s cannot sign %s", sig.signerName, header.domain))
+	}
+
+	if !key.IsZoneKey() || (key.IsRevoked() && !allowRevoked) || key.protocol != 3 {
+		return ValidationError(fmt.Sprintf("Key %d is not a usable zone key", key.KeyTag()))
+	}
+
+	if int(sig.labels) > labelCount(header.domain) {
+		return ValidationError("Signature has too many labels")
+	}
+
+	// The validity window uses serial number arithmetic (RFC 4034 section 3.1.5)
+	now := uint32(time.Now().Unix())
+	if int32(now-sig.inception) < 0 {
+		return ValidationError(fmt.Sprintf("Signature is not valid until %s", formatSigTime(sig.inception)))
+	}
+	if int32(sig.expiration-now) < 0 {
+		return ValidationError(fmt.Sprintf("Signature expired at %s", formatSigTime(sig.expiration)))
+	}
+
+	data, err := signedData(sig, rrset)
+	if err != nil {
+		return err
+	}
+
+	return verifySignature(key, data, sig.signature)
+}
+
+// signedData builds the data an RRSIG signs: its own RDATA without the
+// signature, then each record of the RRset in canonical form and order with
+// the original TTL (RFC 4034 section 3.1.8.1)
+func signedData(sig RrsigRecord, rrset []Record) ([]byte, error) {
+	buffer := newCanonicalBuffer()
+	if err := sig.writeSignedFields(buffer); err != nil {
+		return nil, err
+	}
+
+	// A wildcard expansion is signed under the wildcard's own name
+	owner := normalizeName(rrset[0].Header().domain)
+	if int(sig.labels) < labelCount(owner) {
+		labels := strings.Split(owner, ".")
+		owner = strings.Join(append([]string{"*"}, labels[len(labels)-int(sig.labels):]...), ".")
+	}
+
+	rdatas := make([][]byte, 0, len(rrset))
+	for _, record := range rrset {
+		rdata, err := canonicalRData(record)
+		if err != nil {
+			return nil, err
+		}
+		rdatas = append(rdatas, rdata)
+	}
+	sort.Slice(rdatas, func(i, j int) bool {
+		return bytes.Compare(rdatas[i], rdatas[j]) < 0
+	})
+
+	header := rrset[0].Header()
+	for idx, rdata := range rdatas {
+		if idx > 0 && bytes.Equal(rdata, rdatas[idx-1]) {
+			continue
+		}
+
+		if err := buffer.writeUncompressedQName(owner); err != nil {
+			return nil, err
+		}
+
+		if err := buffer.writeU16(uint16(header.qtype)); err != nil {
+			return nil, err
+		}
+
+		if err := buffer.writeU16(uint16(header.class)); err != nil {
+			return nil, err
+		}
+
+		if err := buffer.writeU32(sig.originalTTL); err != nil {
+			return nil, err
+		}
+
+		if err := buffer.writeU16(uint16(len(rdata))); err != nil {
+			return nil, err
+		}
+
+		if err := buffer.writeBytes(rdata); err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// canonicalRData returns the RDATA of record with its names uncompressed,
+// and lowercased for the types RFC 4034 section 6.2 lists
+func canonicalRData(record Record) ([]byte, error) {
+	buffer := newCanonicalBuffer()
+	if _, err := record.Write(buffer); err != nil {
+		return nil, err
+	}
+
+	// Skip the owner name, then the type, class, TTL and RDATA length
+	data := buffer.Bytes()
+	pos := 0
+	for pos < len(data) && data[pos] != 0 {
+		pos += int(data[pos]) + 1
+	}
+	pos += 11
+	if pos > len(data) {
+		return nil, InvalidInput("Record is too short")
+	}
+
+	return data[pos:], nil
+}
+
+// verifySignature checks signature over data with the public key in key
+func verifySignature(key DnskeyRecord, data []byte, signature []byte) error {
+	switch key.algorithm {
+	case RSASHA256:
+		publicKey, err := parseRsaKey(key.publicKey)
+		if err != nil {
+			return err
+		}
+
+		hashed := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], signature)
+	case ECDSAP256SHA256:
+		// The key is the point's X and Y, the signature is r and s (RFC 6605)
+		publicKey, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append([]byte{4}, key.publicKey...))
+		if err != nil {
+			return err
+		}
+
+		if len(signature) != 64 {
+			return ValidationError("Invalid ECDSA signature length")
+		}
+
+		hashed := sha256.Sum256(data)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(publicKey, hashed[:], r, s) {
+			return ValidationError("ECDSA signature does not verify")
+		}
+		return nil
+	case ED25519:
+		if len(key.publicKey) != ed25519.PublicKeySize {
+			return ValidationError("Invalid Ed25519 key length")
+		}
+
+		if !ed25519.Verify(ed25519.PublicKey(key.publicKey), data, signature) {
+			return ValidationError("Ed25519 signature does not verify")
+		}
+		return nil
+	default:
+		return ValidationError(fmt.Sprintf("Unsupported algorithm %d", key.algorithm))
+	}
+}
+
+// parseRsaKey decodes an RSA public key in the DNSKEY format of RFC 3110: the
+// exponent length in one byte, or a zero and then two bytes, followed by the
+// exponent and the modulus
+func parseRsaKey(data []byte) (*rsa.PublicKey, error) {
+	if len(data) < 3 {
+		return nil, ValidationError("RSA key is too short")
+	}
+
+	expLen := int(data[0])
+	data = data[1:]
+	if expLen == 0 {
+		expLen = int(data[0])<<8 | int(data[1])
+		data = data[2:]
+	}
+
+	if expLen == 0 || expLen > 4 || expLen >= len(data) {
+		return nil, ValidationError("Invalid RSA exponent")
+	}
+
+	exponent := 0
+	for _, b := range data[:expLen] {
+		exponent = exponent<<8 | int(b)
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(data[expLen:]), E: exponent}, nil
+}
+
+// dsMatches reports whether dsRecord is a digest of key
+func dsMatches(dsRecord DsRecord, key DnskeyRecord) bool {
+	if dsRecord.algorithm != key.algorithm || dsRecord.keyTag != key.KeyTag() {
+		return false
+	}
+
+	// The digest covers the key's owner name followed by its RDATA
+	buffer := newCanonicalBuffer()
+	if err := buffer.writeUncompressedQName(key.domain); err != nil {
+		return false
+	}
+
+	if err := key.writeRData(buffer); err != nil {
+		return false
+	}
+
+	switch dsRecord.digestType {
+	case digestSHA256:
+		digest := sha256.Sum256(buffer.Bytes())
+		return bytes.Equal(digest[:], dsRecord.digest)
+	case digestSHA384:
+		digest := sha512.Sum384(buffer.Bytes())
+		return bytes.Equal(digest[:], dsRecord.digest)
+	default:
+		return false
+	}
+}
+
+func supportedAlgorithm(algorithm uint8) bool {
+	return algorithm == RSASHA256 || algorithm == ECDSAP256SHA256 || algorithm == ED25519
+}
+
+func supportedDigest(digestType uint8) bool {
+	return digestType == digestSHA256 || digestType == digestSHA384
+}
@@ -1,10 +1,121 @@
 package main
 
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// listFlag collects every value given for a flag that may be repeated
+type listFlag []string
+
+func (list *listFlag) String() string {
+	return strings.Join(*list, " ")
+}
+
+func (list *listFlag) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+// splitFlag splits a zone=value flag into its two parts
+func splitFlag(name string, value string) (string, string) {
+	zone, rest, ok := strings.Cut(value, "=")
+	if !ok || len(rest) == 0 {
+		log.Fatalf("-%s wants zone=value, not %q", name, value)
+	}
+
+	return zone, rest
+}
+
 func main() {
-	// bytes := [512]byte{0x86, 0x2a, 0x81, 0x80, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x06, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x03, 0x63, 0x6f, 0x6d, 0x00, 0x00, 0x01, 0x00, 0x01, 0xc0, 0x0c, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x01, 0x25, 0x00, 0x04, 0xd8, 0x3a, 0xd3, 0x8e}
+	trustAnchorFile := flag.String("trust-anchor", "", "file of DS records to validate from instead of the root zone's published keys")
+	stateFile := flag.String("trust-anchor-state", "", "file to keep trust anchors in as they roll over (RFC 5011)")
+	reverse := flag.String("x", "", "print the names an address reverse resolves to, then exit")
+	var zoneFiles, zoneKeys listFlag
+	flag.Var(&zoneFiles, "zone", "serve a zone from a master file, as zone=path (repeatable)")
+	flag.Var(&zoneKeys, "zone-keys", "sign a served zone with PEM encoded ECDSA P-256 or Ed25519 keys, as zone=ksk.pem,zsk.pem (repeatable)")
+	flag.Parse()
+
+	for _, value := range zoneFiles {
+		origin, path := splitFlag("zone", value)
+		zone, err := LoadZone(origin, path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		zones = append(zones, zone)
+	}
+
+	for _, value := range zoneKeys {
+		origin, paths := splitFlag("zone-keys", value)
+		kskPath, zskPath, ok := strings.Cut(paths, ",")
+		if !ok {
+			log.Fatalf("-zone-keys wants zone=ksk.pem,zsk.pem, not %q", value)
+		}
+
+		signer, err := NewSigner(origin, kskPath, zskPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		zone := findAuthZone(origin, SOA)
+		if zone == nil || zone.origin != normalizeName(origin) {
+			log.Fatalf("-zone-keys given for %q, which is not a served zone", origin)
+		}
+		if err := zone.UseSigner(signer); err != nil {
+			log.Fatal(err)
+		}
+
+		// The parent zone has to publish this for the chain of trust to reach us
+		dsRecord := signer.DsRecord()
+		fmt.Printf("DS record for the parent of %q: %d %d %d %X\n", zone.origin, dsRecord.keyTag, dsRecord.algorithm, dsRecord.digestType, dsRecord.digest)
+	}
+
+	for _, zone := range zones {
+		fmt.Printf("Serving %s\n", zone)
+	}
+
+	if len(*trustAnchorFile) > 0 {
+		anchors, err := loadTrustAnchors(*trustAnchorFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		trustAnchors = anchors
+	}
+
+	if len(*stateFile) > 0 {
+		store, err := NewTrustAnchorStore(*stateFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		anchorStore = store
+	}
+
+	if len(*reverse) > 0 {
+		ip := net.ParseIP(*reverse)
+		if ip == nil {
+			log.Fatalf("Invalid IP address %q", *reverse)
+		}
+
+		response, err := reverseLookup(ip)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, record := range response.answers {
+			if ptrRecord, ok := record.(PtrRecord); ok {
+				fmt.Println(ptrRecord.host)
+			}
+		}
+		return
+	}
+
+	// bytes := []byte{0x86, 0x2a, 0x81, 0x80, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x06, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x03, 0x63, 0x6f, 0x6d, 0x00, 0x00, 0x01, 0x00, 0x01, 0xc0, 0x0c, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x01, 0x25, 0x00, 0x04, 0xd8, 0x3a, 0xd3, 0x8e}
 
-	// buffer := BytePacketBuffer{bytes, 0}
-	// oldPacket, err := Read(&buffer)
+	// buffer := NewBytePacketBufferFrom(bytes)
+	// oldPacket, err := Read(buffer)
 	// if err != nil {
 	// 	fmt.Printf("I errored, %s\n", err)
 	// }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// normalizeName lowercases a domain name and strips any trailing dot
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// isSubdomain reports whether name is zone itself or lies beneath it
+func isSubdomain(name string, zone string) bool {
+	name = normalizeName(name)
+	zone = normalizeName(zone)
+	if len(zone) == 0 {
+		return true
+	}
+
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// parentName strips the leftmost label from a domain name
+func parentName(name string) string {
+	if idx := strings.Index(name, "."); idx >= 0 {
+		return name[idx+1:]
+	}
+
+	return ""
+}
+
+// compareNames orders two names canonically, comparing their labels from the
+// rightmost one as lowercase octet strings (RFC 4034 section 6.1)
+func compareNames(a string, b string) int {
+	aLabels := []string{}
+	if a = normalizeName(a); len(a) > 0 {
+		aLabels = strings.Split(a, ".")
+	}
+
+	bLabels := []string{}
+	if b = normalizeName(b); len(b) > 0 {
+		bLabels = strings.Split(b, ".")
+	}
+
+	for idx := 1; idx <= len(aLabels) && idx <= len(bLabels); idx++ {
+		if cmp := strings.Compare(aLabels[len(aLabels)-idx], bLabels[len(bLabels)-idx]); cmp != 0 {
+			return cmp
+		}
+	}
+
+	return len(aLabels) - len(bLabels)
+}
+
+// commonAncestor returns the longest name both a and b lie beneath
+func commonAncestor(a string, b string) string {
+	a = normalizeName(a)
+	for !isSubdomain(b, a) {
+		a = parentName(a)
+	}
+
+	return a
+}
+
+// labelCount counts the labels of a name the way the RRSIG labels field does,
+// leaving out the root and a leading wildcard (RFC 4034 section 3.1.3)
+func labelCount(name string) int {
+	name = normalizeName(name)
+	if len(name) == 0 {
+		return 0
+	}
+
+	labels := strings.Split(name, ".")
+	if labels[0] == "*" {
+		return len(labels) - 1
+	}
+
+	return len(labels)
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name used to look up the
+// PTR record for an address
+func reverseName(ip net.IP) string {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ipv4[3], ipv4[2], ipv4[1], ipv4[0])
+	}
+
+	ipv6 := ip.To16()
+	if ipv6 == nil {
+		return ""
+	}
+
+	labels := make([]string, 0, 2*net.IPv6len+2)
+	for idx := net.IPv6len - 1; idx >= 0; idx-- {
+		labels = append(labels, fmt.Sprintf("%x", ipv6[idx]&0x0F), fmt.Sprintf("%x", ipv6[idx]>>4))
+	}
+	labels = append(labels, "ip6", "arpa")
+
+	return strings.Join(labels, ".")
+}
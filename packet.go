@@ -2,9 +2,13 @@ package main
 
 import (
 	"math/rand"
+	"sort"
 	"strings"
 )
 
+// maxChainLength caps how many CNAME and DNAME hops are followed for one name
+const maxChainLength = 16
+
 // Packet represents a DNS packet
 type Packet struct {
 	header      Header
@@ -98,14 +102,86 @@ func (packet *Packet) Write(buffer *BytePacketBuffer) error {
 	return nil
 }
 
+// WriteTruncated writes this packet into a new buffer of at most limit bytes.
+// Whole RRsets are dropped from the additional section first, then from the
+// authority and answer sections, until the packet fits. Losing additional
+// records does not set the TC bit (RFC 2181 section 9); losing anything else does.
+func (packet *Packet) WriteTruncated(limit uint32) (*BytePacketBuffer, error) {
+	trimmed := *packet
+	for {
+		buffer := NewBytePacketBuffer(limit)
+		err := trimmed.Write(buffer)
+		if err == nil {
+			packet.header = trimmed.header
+			return buffer, nil
+		}
+
+		if resources, ok := dropLastRRset(trimmed.resources); ok {
+			trimmed.resources = resources
+		} else if authorities, ok := dropLastRRset(trimmed.authorities); ok {
+			trimmed.authorities = authorities
+			trimmed.header.truncatedMessage = true
+		} else if answers, ok := dropLastRRset(trimmed.answers); ok {
+			trimmed.answers = answers
+			trimmed.header.truncatedMessage = true
+		} else {
+			return nil, err
+		}
+	}
+}
+
+// dropLastRRset removes every record belonging to the same RRset as the last
+// record. The OPT record is never removed, since it describes the message
+// rather than the data. Reports false if nothing could be removed.
+func dropLastRRset(records []Record) ([]Record, bool) {
+	idx := len(records) - 1
+	for idx >= 0 && records[idx].Header().qtype == OPT {
+		idx--
+	}
+	if idx < 0 {
+		return records, false
+	}
+
+	last := records[idx].Header()
+	kept := make([]Record, 0, len(records)-1)
+	for _, record := range records {
+		if !record.Header().sameRRset(last) {
+			kept = append(kept, record)
+		}
+	}
+
+	return kept, true
+}
+
+// IsNoData reports whether this response says the name exists but has no
+// records of the requested type: no error, no answers and an SOA instead of
+// a referral in the authority section.
+func (packet *Packet) IsNoData() bool {
+	if packet.header.rescode != NOERROR || len(packet.answers) > 0 {
+		return false
+	}
+
+	_, ok := negativeTTL(packet.authorities)
+	return ok
+}
+
+// GetOpt returns the OPT record of this packet, if it has one
+func (packet *Packet) GetOpt() (OptRecord, bool) {
+	for _, record := range packet.resources {
+		if opt, ok := record.(OptRecord); ok {
+			return opt, true
+		}
+	}
+
+	return OptRecord{}, false
+}
+
 // GetRandomARecord returns the IP address of a random a record in the answers
 func (packet *Packet) GetRandomARecord() string {
-	aRecords := make([]ARecord, len(packet.answers))
-	idx := 0
+	aRecords := make([]ARecord, 0, len(packet.answers))
 	for _, record := range packet.answers {
 		if aRecord, ok := record.(ARecord); ok {
-			aRecords[idx] = aRecord
-			idx++
+			aRecords = append(aRecords, aRecord)
 		}
 	}
 
@@ -113,10 +189,140 @@ func (packet *Packet) GetRandomARecord() string {
 		return ""
 	}
 
-	idx = rand.Intn(len(packet.answers))
+	idx := rand.Intn(len(aRecords))
 	return aRecords[idx].addr.String()
 }
 
+// SynthesizeCNames walks the alias chain for qname through the answers and
+// adds the CNAME a DNAME implies wherever the server left it out (RFC 6672
+// section 3.4). It reports false if a substituted name would be too long.
+func (packet *Packet) SynthesizeCNames(qname string) bool {
+	name := normalizeName(qname)
+	for hops := 0; hops < maxChainLength; hops++ {
+		if cnameRecord, ok := packet.getCName(name); ok {
+			name = normalizeName(cnameRecord.host)
+			continue
+		}
+
+		idx := -1
+		for recordIdx, record := range packet.answers {
+			if dnameRecord, ok := record.(DnameRecord); ok && isSubdomain(name, dnameRecord.domain) && name != normalizeName(dnameRecord.domain) {
+				idx = recordIdx
+				break
+			}
+		}
+		if idx < 0 {
+			return true
+		}
+
+		dnameRecord := packet.answers[idx].(DnameRecord)
+		substituted, ok := dnameRecord.Substitute(name)
+		if !ok {
+			return false
+		}
+
+		cnameRecord := CNameRecord{name, substituted, dnameRecord.ttl, dnameRecord.class}
+		answers := make([]Record, 0, len(packet.answers)+1)
+		answers = append(answers, packet.answers[:idx+1]...)
+		answers = append(answers, cnameRecord)
+		packet.answers = append(answers, packet.answers[idx+1:]...)
+		name = substituted
+	}
+
+	return true
+}
+
+// hasRRset reports whether the answers hold records of qtype owned by name
+func (packet *Packet) hasRRset(name string, qtype QueryType) bool {
+	for _, record := range packet.answers {
+		header := record.Header()
+		if header.qtype == qtype && normalizeName(header.domain) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getCName returns the CNAME record in the answers owned by name
+func (packet *Packet) getCName(name string) (CNameRecord, bool) {
+	for _, record := range packet.answers {
+		if cnameRecord, ok := record.(CNameRecord); ok && normalizeName(cnameRecord.domain) == name {
+			return cnameRecord, true
+		}
+	}
+
+	return CNameRecord{}, false
+}
+
+// chainTarget follows the CNAME chain for qname through the answers and
+// returns the name it ends at
+func (packet *Packet) chainTarget(qname string) string {
+	name := normalizeName(qname)
+	for hops := 0; hops < maxChainLength; hops++ {
+		cnameRecord, ok := packet.getCName(name)
+		if !ok {
+			break
+		}
+		name = normalizeName(cnameRecord.host)
+	}
+
+	return name
+}
+
+// GetOrderedSrvRecords returns the SRV records in the answers in the order a
+// client should try them: lowest priority first, and within a priority a
+// weighted random order (RFC 2782).
+func (packet *Packet) GetOrderedSrvRecords() []SrvRecord {
+	byPriority := make(map[uint16][]SrvRecord)
+	priorities := []uint16{}
+	for _, record := range packet.answers {
+		if srvRecord, ok := record.(SrvRecord); ok {
+			if _, ok := byPriority[srvRecord.priority]; !ok {
+				priorities = append(priorities, srvRecord.priority)
+			}
+			byPriority[srvRecord.priority] = append(byPriority[srvRecord.priority], srvRecord)
+		}
+	}
+	sort.Slice(priorities, func(i, j int) bool { return priorities[i] < priorities[j] })
+
+	ordered := []SrvRecord{}
+	for _, priority := range priorities {
+		// Records with no weight go first so they only win when the random pick is zero
+		remaining := []SrvRecord{}
+		for _, srvRecord := range byPriority[priority] {
+			if srvRecord.weight == 0 {
+				remaining = append(remaining, srvRecord)
+			}
+		}
+		for _, srvRecord := range byPriority[priority] {
+			if srvRecord.weight > 0 {
+				remaining = append(remaining, srvRecord)
+			}
+		}
+
+		for len(remaining) > 0 {
+			total := 0
+			for _, srvRecord := range remaining {
+				total += int(srvRecord.weight)
+			}
+
+			pick := rand.Intn(total + 1)
+			sum := 0
+			for idx, srvRecord := range remaining {
+				sum += int(srvRecord.weight)
+				if sum >= pick {
+					ordered = append(ordered, srvRecord)
+					remaining = append(remaining[:idx], remaining[idx+1:]...)
+					break
+				}
+			}
+		}
+	}
+
+	return ordered
+}
+
 // GetResolvedNs returns the IP address for a NS record (if possible)
 func (packet *Packet) GetResolvedNs(qname string) string {
 	authorities := make([]ARecord, len(packet.authorities))
@@ -133,7 +339,7 @@ func (packet *Packet) GetResolvedNs(qname string) string {
 						continue
 					}
 
-					newRecord := ARecord{domain: nsRecord.host, addr: aRecord.addr, ttl: aRecord.ttl}
+					newRecord := ARecord{domain: nsRecord.host, addr: aRecord.addr, ttl: aRecord.ttl, class: aRecord.class}
 					authorities[idx] = newRecord
 					idx++
 				}
@@ -149,6 +355,31 @@ func (packet *Packet) GetResolvedNs(qname string) string {
 	return authorities[0].addr.String()
 }
 
+// GetReferralZone returns the zone delegated to by the NS records in the
+// authority section that cover qname
+func (packet *Packet) GetReferralZone(qname string) string {
+	for _, auth := range packet.authorities {
+		if nsRecord, ok := auth.(NsRecord); ok && isSubdomain(qname, nsRecord.domain) {
+			return nsRecord.domain
+		}
+	}
+
+	return ""
+}
+
+// delegation returns the NS records of packet owned by zone, with the rest
+// of the additional section as possible glue
+func (packet *Packet) delegation(zone string) Packet {
+	delegation := Packet{resources: packet.resources}
+	for _, auth := range packet.authorities {
+		if nsRecord, ok := auth.(NsRecord); ok && normalizeName(nsRecord.domain) == normalizeName(zone) {
+			delegation.authorities = append(delegation.authorities, nsRecord)
+		}
+	}
+
+	return delegation
+}
+
 // GetUnresolvedNs returns the next address to query for
 func (packet *Packet) GetUnresolvedNs(qname string) string {
 	authorities := make([]string, len(packet.authorities))
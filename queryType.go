@@ -1,5 +1,11 @@
 package main
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // QueryType represents the class of record in the DNS response
 type QueryType int
 
@@ -9,8 +15,23 @@ const (
 	A       QueryType = 1
 	NS      QueryType = 2
 	CNAME   QueryType = 5
+	SOA     QueryType = 6
+	PTR     QueryType = 12
 	MX      QueryType = 15
+	TXT     QueryType = 16
 	AAAA    QueryType = 28
+	SRV     QueryType = 33
+	NAPTR   QueryType = 35
+	DNAME   QueryType = 39
+	OPT     QueryType = 41
+	DS      QueryType = 43
+	RRSIG   QueryType = 46
+	NSEC    QueryType = 47
+	DNSKEY  QueryType = 48
+	NSEC3   QueryType = 50
+	SVCB    QueryType = 64
+	HTTPS   QueryType = 65
+	CAA     QueryType = 257
 )
 
 func (queryType QueryType) String() string {
@@ -21,11 +42,63 @@ func (queryType QueryType) String() string {
 		return "NS"
 	case CNAME:
 		return "CNAME"
+	case SOA:
+		return "SOA"
+	case PTR:
+		return "PTR"
 	case MX:
 		return "MX"
+	case TXT:
+		return "TXT"
 	case AAAA:
 		return "AAAA"
+	case SRV:
+		return "SRV"
+	case NAPTR:
+		return "NAPTR"
+	case DNAME:
+		return "DNAME"
+	case OPT:
+		return "OPT"
+	case DS:
+		return "DS"
+	case RRSIG:
+		return "RRSIG"
+	case NSEC:
+		return "NSEC"
+	case DNSKEY:
+		return "DNSKEY"
+	case NSEC3:
+		return "NSEC3"
+	case SVCB:
+		return "SVCB"
+	case HTTPS:
+		return "HTTPS"
+	case CAA:
+		return "CAA"
 	default:
 		return "UNKNOWN"
 	}
 }
+
+// knownTypes lists every type this project models
+var knownTypes = []QueryType{A, NS, CNAME, SOA, PTR, MX, TXT, AAAA, SRV, NAPTR, DNAME, OPT, DS, RRSIG, NSEC, DNSKEY, NSEC3, SVCB, HTTPS, CAA}
+
+// parseQueryType reads a type mnemonic as zone files write it, including the
+// generic TYPEnnn form (RFC 3597 section 5)
+func parseQueryType(text string) (QueryType, error) {
+	text = strings.ToUpper(text)
+	for _, qtype := range knownTypes {
+		if qtype.String() == text {
+			return qtype, nil
+		}
+	}
+
+	if number, ok := strings.CutPrefix(text, "TYPE"); ok {
+		if value, err := strconv.ParseUint(number, 10, 16); err == nil {
+			return QueryType(value), nil
+		}
+	}
+
+	return UNKNOWN, InvalidInput(fmt.Sprintf("Unknown type %s", text))
+}
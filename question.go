@@ -6,6 +6,7 @@ import "fmt"
 type Question struct {
 	name  string
 	qType QueryType
+	class Class
 }
 
 // ReadQuestion reads a packet and return the question entry
@@ -20,13 +21,12 @@ func ReadQuestion(buffer *BytePacketBuffer) (Question, error) {
 		return Question{}, err
 	}
 
-	// TODO class
-	_, err = buffer.ReadU16()
+	class, err := buffer.ReadU16()
 	if err != nil {
 		return Question{}, err
 	}
 
-	return Question{name, QueryType(qType)}, nil
+	return Question{name, QueryType(qType), Class(class)}, nil
 }
 
 // Write writes this question to a packet buffer
@@ -39,8 +39,7 @@ func (question Question) Write(buffer *BytePacketBuffer) error {
 		return err
 	}
 
-	// TODO class
-	if err := buffer.writeU16(1); err != nil {
+	if err := buffer.writeU16(uint16(question.class)); err != nil {
 		return err
 	}
 
@@ -48,5 +47,5 @@ func (question Question) Write(buffer *BytePacketBuffer) error {
 }
 
 func (question Question) String() string {
-	return fmt.Sprintf("name: %s, qtype: %s", question.name, question.qType)
+	return fmt.Sprintf("name: %s, qtype: %s, class: %s", question.name, question.qType, question.class)
 }
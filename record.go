@@ -1,21 +1,77 @@
 package main
 
 import (
+	"encoding/base32"
+	"encoding/base64"
 	"fmt"
 	"net"
+	"sort"
+	"strings"
+	"time"
 )
 
 // Record does something
 type Record interface {
 	Write(*BytePacketBuffer) (uint32, error)
+	Header() RecordHeader
+	WithTTL(uint32) Record
+	WithDomain(string) Record
 }
 
-// UnknownRecord represents a DNS record with an unknown type
+// RecordHeader holds the fields shared by every resource record
+type RecordHeader struct {
+	domain string
+	qtype  QueryType
+	class  Class
+	ttl    uint32
+}
+
+// sameRRset reports whether two records belong to the same RRset
+func (header RecordHeader) sameRRset(other RecordHeader) bool {
+	return header.qtype == other.qtype && header.class == other.class && strings.EqualFold(header.domain, other.domain)
+}
+
+// write writes the owner, type, class and TTL of a record followed by a
+// placeholder for the RDATA length, and returns the placeholder's position.
+func (header RecordHeader) write(buffer *BytePacketBuffer) (uint32, error) {
+	if err := buffer.writeQName(header.domain); err != nil {
+		return 0, err
+	}
+
+	if err := buffer.writeU16(uint16(header.qtype)); err != nil {
+		return 0, err
+	}
+
+	if err := buffer.writeU16(uint16(header.class)); err != nil {
+		return 0, err
+	}
+
+	if err := buffer.writeU32(header.ttl); err != nil {
+		return 0, err
+	}
+
+	pos := buffer.Pos()
+	if err := buffer.writeU16(0); err != nil {
+		return 0, err
+	}
+
+	return pos, nil
+}
+
+// finishRData fills in the RDATA length placeholder written at pos
+func finishRData(buffer *BytePacketBuffer, pos uint32) error {
+	size := buffer.Pos() - pos - 2
+	return buffer.SetU16(pos, uint16(size))
+}
+
+// UnknownRecord represents a DNS record with an unknown type. The RDATA is
+// kept as raw bytes so the record passes through unchanged (RFC 3597).
 type UnknownRecord struct {
-	domain  string
-	qtype   uint16
-	dataLen uint16
-	ttl     uint32
+	domain string
+	qtype  uint16
+	data   []byte
+	ttl    uint32
+	class  Class
 }
 
 // ARecord represents a type A DNS record
@@ -23,6 +79,7 @@ type ARecord struct {
 	domain string
 	addr   net.IP
 	ttl    uint32
+	class  Class
 }
 
 // NsRecord represents a type NS DNS record
@@ -30,6 +87,7 @@ type NsRecord struct {
 	domain string
 	host   string
 	ttl    uint32
+	class  Class
 }
 
 // CNameRecord represents a CNAME DNS record
@@ -37,6 +95,38 @@ type CNameRecord struct {
 	domain string
 	host   string
 	ttl    uint32
+	class  Class
+}
+
+// DnameRecord represents a type DNAME DNS record, which redirects every name
+// below its owner to the same name below the target (RFC 6672)
+type DnameRecord struct {
+	domain string
+	target string
+	ttl    uint32
+	class  Class
+}
+
+// SoaRecord represents a type SOA DNS record, which marks the start of a zone
+type SoaRecord struct {
+	domain  string
+	mname   string
+	rname   string
+	serial  uint32
+	refresh uint32
+	retry   uint32
+	expire  uint32
+	minimum uint32
+	ttl     uint32
+	class   Class
+}
+
+// PtrRecord represents a type PTR DNS record, usually mapping an address back to a name
+type PtrRecord struct {
+	domain string
+	host   string
+	ttl    uint32
+	class  Class
 }
 
 // MxRecord represents a type MX DNS record
@@ -45,319 +135,1790 @@ type MxRecord struct {
 	priority uint16
 	host     string
 	ttl      uint32
+	class    Class
+}
+
+// TxtRecord represents a type TXT DNS record holding one or more character-strings
+type TxtRecord struct {
+	domain string
+	texts  []string
+	ttl    uint32
+	class  Class
+}
+
+// SrvRecord represents a type SRV DNS record locating a service (RFC 2782)
+type SrvRecord struct {
+	domain   string
+	priority uint16
+	weight   uint16
+	port     uint16
+	target   string
+	ttl      uint32
+	class    Class
+}
+
+// NaptrRecord represents a type NAPTR DNS record, a rewrite rule used by ENUM and SIP (RFC 3403)
+type NaptrRecord struct {
+	domain      string
+	order       uint16
+	preference  uint16
+	flags       string
+	services    string
+	regexp      string
+	replacement string
+	ttl         uint32
+	class       Class
+}
+
+// CaaRecord represents a type CAA DNS record restricting which certificate
+// authorities may issue for a domain (RFC 8659)
+type CaaRecord struct {
+	domain string
+	flags  uint8
+	tag    string
+	value  string
+	ttl    uint32
+	class  Class
+}
+
+// SvcbRecord represents a type SVCB DNS record binding a service to its
+// endpoint and connection parameters (RFC 9460)
+type SvcbRecord struct {
+	domain   string
+	priority uint16
+	target   string
+	params   []SvcParam
+	ttl      uint32
+	class    Class
+}
+
+// HttpsRecord represents a type HTTPS DNS record, the SVCB variant for HTTP origins
+type HttpsRecord struct {
+	SvcbRecord
+}
+
+// DsRecord represents a type DS DNS record, the digest of a child zone's key
+// published in the parent zone (RFC 4034 section 5)
+type DsRecord struct {
+	domain     string
+	keyTag     uint16
+	algorithm  uint8
+	digestType uint8
+	digest     []byte
+	ttl        uint32
+	class      Class
+}
+
+// RrsigRecord represents a type RRSIG DNS record, the signature over one
+// RRset (RFC 4034 section 3)
+type RrsigRecord struct {
+	domain      string
+	typeCovered QueryType
+	algorithm   uint8
+	labels      uint8
+	originalTTL uint32
+	expiration  uint32
+	inception   uint32
+	keyTag      uint16
+	signerName  string
+	signature   []byte
+	ttl         uint32
+	class       Class
+}
+
+// NsecRecord represents a type NSEC DNS record, which names the next owner in
+// the zone and the types present at its own owner (RFC 4034 section 4)
+type NsecRecord struct {
+	domain     string
+	nextDomain string
+	types      []QueryType
+	ttl        uint32
+	class      Class
+}
+
+// DnskeyRecord represents a type DNSKEY DNS record holding a zone's public key
+// (RFC 4034 section 2)
+type DnskeyRecord struct {
+	domain    string
+	flags     uint16
+	protocol  uint8
+	algorithm uint8
+	publicKey []byte
+	ttl       uint32
+	class     Class
+}
+
+// Nsec3Record represents a type NSEC3 DNS record, the hashed counterpart of
+// NSEC (RFC 5155)
+type Nsec3Record struct {
+	domain        string
+	hashAlgorithm uint8
+	flags         uint8
+	iterations    uint16
+	salt          []byte
+	nextHashed    []byte
+	types         []QueryType
+	ttl           uint32
+	class         Class
+}
+
+// EdnsOption is a single option carried in an OPT record
+type EdnsOption struct {
+	code uint16
+	data []byte
+}
+
+// OptRecord represents the EDNS0 OPT pseudo-record (RFC 6891). It reuses the
+// class field for the UDP payload size and the TTL field for flags.
+type OptRecord struct {
+	udpSize       uint16
+	extendedRcode uint8
+	version       uint8
+	dnssecOk      bool
+	options       []EdnsOption
+}
+
+// AaaaRecord represents a type AAAA DNS Record
+type AaaaRecord struct {
+	domain string
+	addr   net.IP
+	ttl    uint32
+	class  Class
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record UnknownRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, QueryType(record.qtype), record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record ARecord) Header() RecordHeader {
+	return RecordHeader{record.domain, A, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record NsRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, NS, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record CNameRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, CNAME, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record DnameRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, DNAME, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record SoaRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, SOA, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record PtrRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, PTR, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record MxRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, MX, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record TxtRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, TXT, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record AaaaRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, AAAA, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record SrvRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, SRV, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record NaptrRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, NAPTR, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record CaaRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, CAA, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record SvcbRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, SVCB, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record HttpsRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, HTTPS, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record DsRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, DS, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record RrsigRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, RRSIG, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record NsecRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, NSEC, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record DnskeyRecord) Header() RecordHeader {
+	return RecordHeader{record.domain, DNSKEY, record.class, record.ttl}
+}
+
+// Header returns the owner, type, class and TTL of this record
+func (record Nsec3Record) Header() RecordHeader {
+	return RecordHeader{record.domain, NSEC3, record.class, record.ttl}
 }
 
-// AaaaRecord represents a type AAAA DNS Record
-type AaaaRecord struct {
-	domain string
-	addr   net.IP
-	ttl    uint32
+// Header returns the owner and type of this record. The OPT record reuses
+// the class and TTL fields for other purposes, so neither is reported.
+func (record OptRecord) Header() RecordHeader {
+	return RecordHeader{"", OPT, 0, 0}
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record UnknownRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record ARecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record NsRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record CNameRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record DnameRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record SoaRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record PtrRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record MxRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record TxtRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record AaaaRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record SrvRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record NaptrRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record CaaRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record SvcbRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record HttpsRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record DsRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record RrsigRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record NsecRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record DnskeyRecord) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns a copy of this record with a different TTL
+func (record Nsec3Record) WithTTL(ttl uint32) Record {
+	record.ttl = ttl
+	return record
+}
+
+// WithTTL returns this record unchanged, since the OPT record has no TTL
+func (record OptRecord) WithTTL(_ uint32) Record {
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record UnknownRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record ARecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record NsRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record CNameRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record DnameRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record SoaRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record PtrRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record MxRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record TxtRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record AaaaRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record SrvRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record NaptrRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record CaaRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record SvcbRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record HttpsRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record DsRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record RrsigRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record NsecRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record DnskeyRecord) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns a copy of this record with a different owner
+func (record Nsec3Record) WithDomain(domain string) Record {
+	record.domain = domain
+	return record
+}
+
+// WithDomain returns this record unchanged, since the OPT record has no owner
+func (record OptRecord) WithDomain(_ string) Record {
+	return record
+}
+
+func (record *ARecord) String() string {
+	return fmt.Sprintf("domain: %s, addr: %s, ttl: %d, class: %s", record.domain, record.addr, record.ttl, record.class)
+}
+
+func readARecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32) (ARecord, error) {
+	rawAddress, err := buffer.ReadU32()
+	if err != nil {
+		return ARecord{}, err
+	}
+
+	addr := net.IPv4(byte(rawAddress>>24&0xFF), byte(rawAddress>>16&0xFF), byte(rawAddress>>8&0xFF), byte(rawAddress&0xFF))
+	return ARecord{domain, addr, ttl, class}, nil
+}
+
+func readAaaaRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32) (AaaaRecord, error) {
+	rawAddress1, err := buffer.ReadU32()
+	if err != nil {
+		return AaaaRecord{}, err
+	}
+	rawAddress2, err := buffer.ReadU32()
+	if err != nil {
+		return AaaaRecord{}, err
+	}
+	rawAddress3, err := buffer.ReadU32()
+	if err != nil {
+		return AaaaRecord{}, err
+	}
+	rawAddress4, err := buffer.ReadU32()
+	if err != nil {
+		return AaaaRecord{}, err
+	}
+
+	addr := make(net.IP, net.IPv6len)
+	addr[0] = byte(rawAddress1>>24) & 0XFF
+	addr[1] = byte(rawAddress1>>16) & 0xFF
+	addr[2] = byte(rawAddress1>>8) & 0xFF
+	addr[3] = byte(rawAddress1>>0) & 0xFF
+	addr[4] = byte(rawAddress2>>24) & 0XFF
+	addr[5] = byte(rawAddress2>>16) & 0xFF
+	addr[6] = byte(rawAddress2>>8) & 0xFF
+	addr[7] = byte(rawAddress2>>0) & 0xFF
+	addr[8] = byte(rawAddress3>>24) & 0XFF
+	addr[9] = byte(rawAddress3>>16) & 0xFF
+	addr[10] = byte(rawAddress3>>8) & 0xFF
+	addr[11] = byte(rawAddress3>>0) & 0xFF
+	addr[12] = byte(rawAddress4>>24) & 0XFF
+	addr[13] = byte(rawAddress4>>16) & 0xFF
+	addr[14] = byte(rawAddress4>>8) & 0xFF
+	addr[15] = byte(rawAddress4>>0) & 0xFF
+
+	return AaaaRecord{domain, addr, ttl, class}, nil
+}
+
+func readNsRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32) (NsRecord, error) {
+	host, err := buffer.ReadQName()
+	if err != nil {
+		return NsRecord{}, err
+	}
+
+	return NsRecord{domain, host, ttl, class}, nil
+}
+
+func readCNameRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32) (CNameRecord, error) {
+	host, err := buffer.ReadQName()
+	if err != nil {
+		return CNameRecord{}, err
+	}
+	return CNameRecord{domain, host, ttl, class}, nil
+}
+
+func readDnameRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32) (DnameRecord, error) {
+	target, err := buffer.ReadQName()
+	if err != nil {
+		return DnameRecord{}, err
+	}
+	return DnameRecord{domain, target, ttl, class}, nil
+}
+
+func readSoaRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32) (SoaRecord, error) {
+	mname, err := buffer.ReadQName()
+	if err != nil {
+		return SoaRecord{}, err
+	}
+
+	rname, err := buffer.ReadQName()
+	if err != nil {
+		return SoaRecord{}, err
+	}
+
+	fields := make([]uint32, 5)
+	for idx := range fields {
+		field, err := buffer.ReadU32()
+		if err != nil {
+			return SoaRecord{}, err
+		}
+		fields[idx] = field
+	}
+
+	return SoaRecord{domain, mname, rname, fields[0], fields[1], fields[2], fields[3], fields[4], ttl, class}, nil
+}
+
+func readPtrRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32) (PtrRecord, error) {
+	host, err := buffer.ReadQName()
+	if err != nil {
+		return PtrRecord{}, err
+	}
+
+	return PtrRecord{domain, host, ttl, class}, nil
+}
+
+func readMxRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32) (MxRecord, error) {
+	priority, err := buffer.ReadU16()
+	if err != nil {
+		return MxRecord{}, err
+	}
+
+	host, err := buffer.ReadQName()
+	if err != nil {
+		return MxRecord{}, err
+	}
+
+	return MxRecord{domain, priority, host, ttl, class}, nil
+}
+
+func readTxtRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32, dataLen uint16) (TxtRecord, error) {
+	texts := []string{}
+	end := buffer.Pos() + uint32(dataLen)
+	for buffer.Pos() < end {
+		text, err := buffer.ReadCharacterString()
+		if err != nil {
+			return TxtRecord{}, err
+		}
+		texts = append(texts, text)
+	}
+
+	return TxtRecord{domain, texts, ttl, class}, nil
+}
+
+func readSrvRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32) (SrvRecord, error) {
+	priority, err := buffer.ReadU16()
+	if err != nil {
+		return SrvRecord{}, err
+	}
+
+	weight, err := buffer.ReadU16()
+	if err != nil {
+		return SrvRecord{}, err
+	}
+
+	port, err := buffer.ReadU16()
+	if err != nil {
+		return SrvRecord{}, err
+	}
+
+	target, err := buffer.ReadQName()
+	if err != nil {
+		return SrvRecord{}, err
+	}
+
+	return SrvRecord{domain, priority, weight, port, target, ttl, class}, nil
+}
+
+func readNaptrRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32) (NaptrRecord, error) {
+	order, err := buffer.ReadU16()
+	if err != nil {
+		return NaptrRecord{}, err
+	}
+
+	preference, err := buffer.ReadU16()
+	if err != nil {
+		return NaptrRecord{}, err
+	}
+
+	texts := make([]string, 3)
+	for idx := range texts {
+		text, err := buffer.ReadCharacterString()
+		if err != nil {
+			return NaptrRecord{}, err
+		}
+		texts[idx] = text
+	}
+
+	replacement, err := buffer.ReadQName()
+	if err != nil {
+		return NaptrRecord{}, err
+	}
+
+	return NaptrRecord{domain, order, preference, texts[0], texts[1], texts[2], replacement, ttl, class}, nil
+}
+
+func readCaaRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32, dataLen uint16) (CaaRecord, error) {
+	end := buffer.Pos() + uint32(dataLen)
+	flags, err := buffer.Read()
+	if err != nil {
+		return CaaRecord{}, err
+	}
+
+	tagLen, err := buffer.Read()
+	if err != nil {
+		return CaaRecord{}, err
+	}
+
+	tag, err := buffer.GetRange(buffer.Pos(), uint32(tagLen))
+	if err != nil {
+		return CaaRecord{}, err
+	}
+	buffer.Step(uint32(tagLen))
+
+	if buffer.Pos() > end {
+		return CaaRecord{}, InvalidInput("CAA tag runs past the end of the record")
+	}
+
+	value, err := buffer.GetRange(buffer.Pos(), end-buffer.Pos())
+	if err != nil {
+		return CaaRecord{}, err
+	}
+
+	return CaaRecord{domain, flags, string(tag), string(value), ttl, class}, nil
+}
+
+func readSvcbRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32, dataLen uint16) (SvcbRecord, error) {
+	end := buffer.Pos() + uint32(dataLen)
+	priority, err := buffer.ReadU16()
+	if err != nil {
+		return SvcbRecord{}, err
+	}
+
+	target, err := buffer.ReadQName()
+	if err != nil {
+		return SvcbRecord{}, err
+	}
+
+	params := []SvcParam{}
+	for buffer.Pos() < end {
+		key, err := buffer.ReadU16()
+		if err != nil {
+			return SvcbRecord{}, err
+		}
+
+		// Keys must appear in strictly increasing order
+		if len(params) > 0 && SvcParamKey(key) <= params[len(params)-1].Key() {
+			return SvcbRecord{}, InvalidInput(fmt.Sprintf("SvcParamKey %d is out of order", key))
+		}
+
+		len, err := buffer.ReadU16()
+		if err != nil {
+			return SvcbRecord{}, err
+		}
+
+		if buffer.Pos()+uint32(len) > end {
+			return SvcbRecord{}, InvalidInput(fmt.Sprintf("SvcParam %d overruns the RDATA", key))
+		}
+
+		value, err := buffer.GetRange(buffer.Pos(), uint32(len))
+		if err != nil {
+			return SvcbRecord{}, err
+		}
+		buffer.Step(uint32(len))
+
+		param, err := unpackSvcParam(SvcParamKey(key), value)
+		if err != nil {
+			return SvcbRecord{}, err
+		}
+		params = append(params, param)
+	}
+
+	return SvcbRecord{domain, priority, target, params, ttl, class}, nil
+}
+
+func readDsRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32, dataLen uint16) (DsRecord, error) {
+	end := buffer.Pos() + uint32(dataLen)
+	keyTag, err := buffer.ReadU16()
+	if err != nil {
+		return DsRecord{}, err
+	}
+
+	algorithm, err := buffer.Read()
+	if err != nil {
+		return DsRecord{}, err
+	}
+
+	digestType, err := buffer.Read()
+	if err != nil {
+		return DsRecord{}, err
+	}
+
+	digest, err := buffer.readRest(end)
+	if err != nil {
+		return DsRecord{}, err
+	}
+
+	return DsRecord{domain, keyTag, algorithm, digestType, digest, ttl, class}, nil
+}
+
+func readRrsigRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32, dataLen uint16) (RrsigRecord, error) {
+	end := buffer.Pos() + uint32(dataLen)
+	typeCovered, err := buffer.ReadU16()
+	if err != nil {
+		return RrsigRecord{}, err
+	}
+
+	algorithm, err := buffer.Read()
+	if err != nil {
+		return RrsigRecord{}, err
+	}
+
+	labels, err := buffer.Read()
+	if err != nil {
+		return RrsigRecord{}, err
+	}
+
+	times := make([]uint32, 3)
+	for idx := range times {
+		field, err := buffer.ReadU32()
+		if err != nil {
+			return RrsigRecord{}, err
+		}
+		times[idx] = field
+	}
+
+	keyTag, err := buffer.ReadU16()
+	if err != nil {
+		return RrsigRecord{}, err
+	}
+
+	signerName, err := buffer.ReadQName()
+	if err != nil {
+		return RrsigRecord{}, err
+	}
+
+	signature, err := buffer.readRest(end)
+	if err != nil {
+		return RrsigRecord{}, err
+	}
+
+	return RrsigRecord{domain, QueryType(typeCovered), algorithm, labels, times[0], times[1], times[2], keyTag, signerName, signature, ttl, class}, nil
+}
+
+func readNsecRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32, dataLen uint16) (NsecRecord, error) {
+	end := buffer.Pos() + uint32(dataLen)
+	// The next name is signed in the case the zone wrote it in (RFC 6840 section 5.1)
+	nextDomain, err := buffer.readExactQName()
+	if err != nil {
+		return NsecRecord{}, err
+	}
+
+	bitmap, err := buffer.readRest(end)
+	if err != nil {
+		return NsecRecord{}, err
+	}
+
+	types, err := unpackTypeBitmap(bitmap)
+	if err != nil {
+		return NsecRecord{}, err
+	}
+
+	return NsecRecord{domain, nextDomain, types, ttl, class}, nil
+}
+
+func readDnskeyRecord(buffer *BytePacketBuffer, domain string, class Class, ttl uint32, dataLen uint16) (DnskeyRecord, error) {
+	end := buffer.Pos() + uint32(dataLen)
+	flags, err := buffer.ReadU16()
+	if err != nil {
+		return DnskeyRecord{}, err
+	}
+
+	protocol, err := buffer.Read()
+	if err != nil {
+		return DnskeyRecord{}, err
+	}
+
+	algorithm, err := buffer.Read()
+	if err != nil {
+		return DnskeyRecord{}, err
+	}
+
+	publicKey, err := buffer.readRest(end)
+	if err != nil {
+		return DnskeyRecord{}, err
+	}
+
+	return DnskeyRecord{domain, flags, protocol, algorithm, publicKey, ttl, class}, nil
+}
+
+func readNsec3Record(buffer *BytePacketBuffer, domain string, class Class, ttl uint32, dataLen uint16) (Nsec3Record, error) {
+	end := buffer.Pos() + uint32(dataLen)
+	hashAlgorithm, err := buffer.Read()
+	if err != nil {
+		return Nsec3Record{}, err
+	}
+
+	flags, err := buffer.Read()
+	if err != nil {
+		return Nsec3Record{}, err
+	}
+
+	iterations, err := buffer.ReadU16()
+	if err != nil {
+		return Nsec3Record{}, err
+	}
+
+	salt, err := buffer.ReadCharacterString()
+	if err != nil {
+		return Nsec3Record{}, err
+	}
+
+	nextHashed, err := buffer.ReadCharacterString()
+	if err != nil {
+		return Nsec3Record{}, err
+	}
+
+	bitmap, err := buffer.readRest(end)
+	if err != nil {
+		return Nsec3Record{}, err
+	}
+
+	types, err := unpackTypeBitmap(bitmap)
+	if err != nil {
+		return Nsec3Record{}, err
+	}
+
+	return Nsec3Record{domain, hashAlgorithm, flags, iterations, []byte(salt), []byte(nextHashed), types, ttl, class}, nil
+}
+
+func readOptRecord(buffer *BytePacketBuffer, udpSize uint16, flags uint32, dataLen uint16) (OptRecord, error) {
+	record := OptRecord{
+		udpSize:       udpSize,
+		extendedRcode: uint8(flags >> 24),
+		version:       uint8(flags >> 16),
+		dnssecOk:      flags&(1<<15) > 0,
+	}
+
+	end := buffer.Pos() + uint32(dataLen)
+	for buffer.Pos() < end {
+		// Every option has to fit inside the RDATA, or it would run into the next record
+		if buffer.Pos()+4 > end {
+			return OptRecord{}, InvalidInput("Truncated EDNS option header")
+		}
+
+		code, err := buffer.ReadU16()
+		if err != nil {
+			return OptRecord{}, err
+		}
+
+		len, err := buffer.ReadU16()
+		if err != nil {
+			return OptRecord{}, err
+		}
+
+		if buffer.Pos()+uint32(len) > end {
+			return OptRecord{}, InvalidInput(fmt.Sprintf("EDNS option %d overruns the OPT record", code))
+		}
+
+		data, err := buffer.GetRange(buffer.Pos(), uint32(len))
+		if err != nil {
+			return OptRecord{}, err
+		}
+		buffer.Step(uint32(len))
+
+		record.options = append(record.options, EdnsOption{code, data})
+	}
+
+	return record, nil
+}
+
+// ReadRecord reads a DNS record from a buffer
+func ReadRecord(buffer *BytePacketBuffer) (Record, error) {
+	domain, err := buffer.ReadQName()
+	if err != nil {
+		return UnknownRecord{}, err
+	}
+
+	qtype, err := buffer.ReadU16()
+	if err != nil {
+		return UnknownRecord{}, err
+	}
+
+	class, err := buffer.ReadU16()
+	if err != nil {
+		return UnknownRecord{}, err
+	}
+
+	ttl, err := buffer.ReadU32()
+	if err != nil {
+		return UnknownRecord{}, err
+	}
+
+	dataLen, err := buffer.ReadU16()
+	if err != nil {
+		return UnknownRecord{}, err
+	}
+
+	start := buffer.Pos()
+	var record Record
+	switch QueryType(qtype) {
+	case A:
+		record, err = readARecord(buffer, domain, Class(class), ttl)
+	case AAAA:
+		record, err = readAaaaRecord(buffer, domain, Class(class), ttl)
+	case NS:
+		record, err = readNsRecord(buffer, domain, Class(class), ttl)
+	case CNAME:
+		record, err = readCNameRecord(buffer, domain, Class(class), ttl)
+	case DNAME:
+		record, err = readDnameRecord(buffer, domain, Class(class), ttl)
+	case SOA:
+		record, err = readSoaRecord(buffer, domain, Class(class), ttl)
+	case PTR:
+		record, err = readPtrRecord(buffer, domain, Class(class), ttl)
+	case MX:
+		record, err = readMxRecord(buffer, domain, Class(class), ttl)
+	case TXT:
+		record, err = readTxtRecord(buffer, domain, Class(class), ttl, dataLen)
+	case SRV:
+		record, err = readSrvRecord(buffer, domain, Class(class), ttl)
+	case NAPTR:
+		record, err = readNaptrRecord(buffer, domain, Class(class), ttl)
+	case CAA:
+		record, err = readCaaRecord(buffer, domain, Class(class), ttl, dataLen)
+	case SVCB, HTTPS:
+		var svcbRecord SvcbRecord
+		svcbRecord, err = readSvcbRecord(buffer, domain, Class(class), ttl, dataLen)
+		record = svcbRecord
+		if QueryType(qtype) == HTTPS {
+			record = HttpsRecord{svcbRecord}
+		}
+
+		// A service binding with parameters we cannot interpret still passes
+		// through as raw RDATA rather than failing the whole message
+		if err != nil {
+			var data []byte
+			data, err = buffer.GetRange(start, uint32(dataLen))
+			record = UnknownRecord{domain, qtype, data, ttl, Class(class)}
+		}
+	case DS:
+		record, err = readDsRecord(buffer, domain, Class(class), ttl, dataLen)
+	case RRSIG:
+		record, err = readRrsigRecord(buffer, domain, Class(class), ttl, dataLen)
+	case NSEC:
+		record, err = readNsecRecord(buffer, domain, Class(class), ttl, dataLen)
+	case DNSKEY:
+		record, err = readDnskeyRecord(buffer, domain, Class(class), ttl, dataLen)
+	case NSEC3:
+		record, err = readNsec3Record(buffer, domain, Class(class), ttl, dataLen)
+	case OPT:
+		record, err = readOptRecord(buffer, class, ttl, dataLen)
+	default:
+		var data []byte
+		data, err = buffer.GetRange(start, uint32(dataLen))
+		record = UnknownRecord{domain, qtype, data, ttl, Class(class)}
+	}
+	if err != nil {
+		return UnknownRecord{}, err
+	}
+
+	// Always continue after the RDATA, however much of it the reader consumed
+	if err := buffer.Seek(start + uint32(dataLen)); err != nil {
+		return UnknownRecord{}, err
+	}
+
+	return record, nil
+}
+
+// Write writes this record to a buffer
+func (record ARecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, octet := range record.addr.To4() {
+		if err := buffer.write(octet); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
+	}
+
+	return buffer.Pos() - startPos, nil
+}
+
+func (record AaaaRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, octet := range record.addr.To16() {
+		if err := buffer.write(octet); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
+	}
+
+	return buffer.Pos() - startPos, nil
+}
+
+func (record NsRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := buffer.writeQName(record.host); err != nil {
+		return 0, err
+	}
+
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
+	}
+
+	return buffer.Pos() - startPos, nil
+}
+
+func (record CNameRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := buffer.writeQName(record.host); err != nil {
+		return 0, err
+	}
+
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
+	}
+
+	return buffer.Pos() - startPos, nil
+}
+
+func (record DnameRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
+	if err != nil {
+		return 0, err
+	}
+
+	// RFC 6672 forbids compressing the target
+	if err := buffer.writeUncompressedQName(record.target); err != nil {
+		return 0, err
+	}
+
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
+	}
+
+	return buffer.Pos() - startPos, nil
+}
+
+func (record DnameRecord) String() string {
+	return fmt.Sprintf("domain: %s, target: %s, ttl: %d, class: %s", record.domain, record.target, record.ttl, record.class)
+}
+
+// Substitute rewrites a name below the owner of this record into the same
+// name below its target. It fails if name is not strictly below the owner or
+// the result would be longer than a domain name may be.
+func (record DnameRecord) Substitute(name string) (string, bool) {
+	name = normalizeName(name)
+	owner := normalizeName(record.domain)
+	if name == owner || !isSubdomain(name, owner) {
+		return "", false
+	}
+
+	prefix := name
+	if len(owner) > 0 {
+		prefix = strings.TrimSuffix(name, "."+owner)
+	}
+
+	substituted := prefix
+	if target := normalizeName(record.target); len(target) > 0 {
+		substituted = prefix + "." + target
+	}
+
+	// Each label costs its length plus one, and the root label one more
+	if len(substituted)+2 > 255 {
+		return "", false
+	}
+
+	return substituted, true
+}
+
+func (record SoaRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := buffer.writeQName(record.mname); err != nil {
+		return 0, err
+	}
+
+	if err := buffer.writeQName(record.rname); err != nil {
+		return 0, err
+	}
+
+	for _, field := range []uint32{record.serial, record.refresh, record.retry, record.expire, record.minimum} {
+		if err := buffer.writeU32(field); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
+	}
+
+	return buffer.Pos() - startPos, nil
+}
+
+func (record SoaRecord) String() string {
+	return fmt.Sprintf("domain: %s, mname: %s, rname: %s, serial: %d, refresh: %d, retry: %d, expire: %d, minimum: %d, ttl: %d, class: %s",
+		record.domain, record.mname, record.rname, record.serial, record.refresh, record.retry, record.expire, record.minimum, record.ttl, record.class)
+}
+
+func (record PtrRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := buffer.writeQName(record.host); err != nil {
+		return 0, err
+	}
+
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
+	}
+
+	return buffer.Pos() - startPos, nil
+}
+
+func (record PtrRecord) String() string {
+	return fmt.Sprintf("domain: %s, host: %s, ttl: %d, class: %s", record.domain, record.host, record.ttl, record.class)
+}
+
+func (record MxRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := buffer.writeU16(record.priority); err != nil {
+		return 0, err
+	}
+
+	if err := buffer.writeQName(record.host); err != nil {
+		return 0, err
+	}
+
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
+	}
+
+	return buffer.Pos() - startPos, nil
+}
+
+func (record TxtRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, text := range record.texts {
+		if err := buffer.writeCharacterString(text); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
+	}
+
+	return buffer.Pos() - startPos, nil
+}
+
+func (record TxtRecord) String() string {
+	quoted := make([]string, len(record.texts))
+	for idx, text := range record.texts {
+		quoted[idx] = fmt.Sprintf("%q", text)
+	}
+
+	return fmt.Sprintf("domain: %s, txt: %s, ttl: %d, class: %s", record.domain, strings.Join(quoted, " "), record.ttl, record.class)
+}
+
+func (record SrvRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, field := range []uint16{record.priority, record.weight, record.port} {
+		if err := buffer.writeU16(field); err != nil {
+			return 0, err
+		}
+	}
+
+	// RFC 2782 forbids compressing the target
+	if err := buffer.writeUncompressedQName(record.target); err != nil {
+		return 0, err
+	}
+
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
+	}
+
+	return buffer.Pos() - startPos, nil
 }
 
-func (record *ARecord) String() string {
-	return fmt.Sprintf("domain: %s, addr: %s, ttl: %d", record.domain, record.addr, record.ttl)
+func (record SrvRecord) String() string {
+	return fmt.Sprintf("domain: %s, priority: %d, weight: %d, port: %d, target: %s, ttl: %d, class: %s",
+		record.domain, record.priority, record.weight, record.port, record.target, record.ttl, record.class)
 }
 
-func readARecord(buffer *BytePacketBuffer, domain string, ttl uint32) (ARecord, error) {
-	rawAddress, err := buffer.ReadU32()
+func (record NaptrRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
 	if err != nil {
-		return ARecord{}, err
+		return 0, err
 	}
 
-	addr := net.IPv4(byte(rawAddress>>24&0xFF), byte(rawAddress>>16&0xFF), byte(rawAddress>>8&0xFF), byte(rawAddress&0xFF))
-	return ARecord{domain, addr, ttl}, nil
-}
-
-func readAaaaRecord(buffer *BytePacketBuffer, domain string, ttl uint32) (AaaaRecord, error) {
-	rawAddress1, err := buffer.ReadU32()
-	if err != nil {
-		return AaaaRecord{}, err
+	if err := buffer.writeU16(record.order); err != nil {
+		return 0, err
 	}
-	rawAddress2, err := buffer.ReadU32()
-	if err != nil {
-		return AaaaRecord{}, err
+
+	if err := buffer.writeU16(record.preference); err != nil {
+		return 0, err
 	}
-	rawAddress3, err := buffer.ReadU32()
-	if err != nil {
-		return AaaaRecord{}, err
+
+	for _, text := range []string{record.flags, record.services, record.regexp} {
+		if err := buffer.writeCharacterString(text); err != nil {
+			return 0, err
+		}
 	}
-	rawAddress4, err := buffer.ReadU32()
-	if err != nil {
-		return AaaaRecord{}, err
+
+	// RFC 3403 forbids compressing the replacement
+	if err := buffer.writeUncompressedQName(record.replacement); err != nil {
+		return 0, err
 	}
 
-	addr := make(net.IP, net.IPv6len)
-	addr[0] = byte(rawAddress1>>24) & 0XFF
-	addr[1] = byte(rawAddress1>>16) & 0xFF
-	addr[2] = byte(rawAddress1>>8) & 0xFF
-	addr[3] = byte(rawAddress1>>0) & 0xFF
-	addr[4] = byte(rawAddress2>>24) & 0XFF
-	addr[5] = byte(rawAddress2>>16) & 0xFF
-	addr[6] = byte(rawAddress2>>8) & 0xFF
-	addr[7] = byte(rawAddress2>>0) & 0xFF
-	addr[8] = byte(rawAddress3>>24) & 0XFF
-	addr[9] = byte(rawAddress3>>16) & 0xFF
-	addr[10] = byte(rawAddress3>>8) & 0xFF
-	addr[11] = byte(rawAddress3>>0) & 0xFF
-	addr[12] = byte(rawAddress4>>24) & 0XFF
-	addr[13] = byte(rawAddress4>>16) & 0xFF
-	addr[14] = byte(rawAddress4>>8) & 0xFF
-	addr[15] = byte(rawAddress4>>0) & 0xFF
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
+	}
 
-	return AaaaRecord{domain, addr, ttl}, nil
+	return buffer.Pos() - startPos, nil
 }
 
-func readNsRecord(buffer *BytePacketBuffer, domain string, ttl uint32) (NsRecord, error) {
-	host, err := buffer.ReadQName()
-	if err != nil {
-		return NsRecord{}, err
+func (record NaptrRecord) String() string {
+	replacement := record.replacement
+	if len(replacement) == 0 {
+		replacement = "."
 	}
 
-	return NsRecord{domain, host, ttl}, nil
+	return fmt.Sprintf("domain: %s, order: %d, preference: %d, flags: %s, services: %s, regexp: %s, replacement: %s, ttl: %d, class: %s",
+		record.domain, record.order, record.preference, quoteString(record.flags), quoteString(record.services), quoteString(record.regexp), replacement, record.ttl, record.class)
 }
 
-func readCNameRecord(buffer *BytePacketBuffer, domain string, ttl uint32) (CNameRecord, error) {
-	host, err := buffer.ReadQName()
-	if err != nil {
-		return CNameRecord{}, err
+func (record CaaRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	if len(record.tag) == 0 {
+		return 0, InvalidInput("CAA tag must not be empty")
 	}
-	return CNameRecord{domain, host, ttl}, nil
-}
 
-func readMxRecord(buffer *BytePacketBuffer, domain string, ttl uint32) (MxRecord, error) {
-	priority, err := buffer.ReadU16()
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
 	if err != nil {
-		return MxRecord{}, err
+		return 0, err
 	}
 
-	host, err := buffer.ReadQName()
-	if err != nil {
-		return MxRecord{}, err
+	if err := buffer.write(record.flags); err != nil {
+		return 0, err
 	}
 
-	return MxRecord{domain, priority, host, ttl}, nil
-}
+	if err := buffer.writeCharacterString(record.tag); err != nil {
+		return 0, err
+	}
 
-// ReadRecord reads a DNS record from a buffer
-func ReadRecord(buffer *BytePacketBuffer) (Record, error) {
-	domain, err := buffer.ReadQName()
-	if err != nil {
-		return UnknownRecord{}, err
+	// The value takes up the rest of the RDATA, without a length prefix
+	for _, b := range []byte(record.value) {
+		if err := buffer.write(b); err != nil {
+			return 0, err
+		}
 	}
 
-	qtype, err := buffer.ReadU16()
-	if err != nil {
-		return UnknownRecord{}, err
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
 	}
 
-	// TODO Class
-	if _, err := buffer.ReadU16(); err != nil {
-		return UnknownRecord{}, err
+	return buffer.Pos() - startPos, nil
+}
+
+// String formats this record the way it would appear in a zone file
+func (record CaaRecord) String() string {
+	return fmt.Sprintf("%s. %d %s CAA %d %s %s", record.domain, record.ttl, record.class, record.flags, record.tag, quoteString(record.value))
+}
+
+// quoteString formats text as a quoted zone file string, escaping quotes,
+// backslashes and unprintable bytes as \DDD (RFC 1035 section 5.1)
+func quoteString(text string) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for _, b := range []byte(text) {
+		switch {
+		case b == '"' || b == '\\':
+			builder.WriteByte('\\')
+			builder.WriteByte(b)
+		case b < 0x20 || b > 0x7E:
+			fmt.Fprintf(&builder, "\\%03d", b)
+		default:
+			builder.WriteByte(b)
+		}
 	}
+	builder.WriteByte('"')
 
-	ttl, err := buffer.ReadU32()
-	if err != nil {
-		return UnknownRecord{}, err
+	return builder.String()
+}
+
+func (record SvcbRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	return record.writeAs(buffer, record.Header())
+}
+
+func (record HttpsRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	return record.writeAs(buffer, record.Header())
+}
+
+// writeAs writes the service binding under the given header, so SVCB and
+// HTTPS records share one encoder
+func (record SvcbRecord) writeAs(buffer *BytePacketBuffer, header RecordHeader) (uint32, error) {
+	params := make([]SvcParam, len(record.params))
+	copy(params, record.params)
+	sort.Slice(params, func(i, j int) bool { return params[i].Key() < params[j].Key() })
+	for idx := 1; idx < len(params); idx++ {
+		if params[idx].Key() == params[idx-1].Key() {
+			return 0, InvalidInput(fmt.Sprintf("Duplicate SvcParamKey %s", params[idx].Key()))
+		}
 	}
 
-	dataLen, err := buffer.ReadU16()
+	startPos := buffer.Pos()
+	pos, err := header.write(buffer)
 	if err != nil {
-		return UnknownRecord{}, err
+		return 0, err
 	}
 
-	switch QueryType(qtype) {
-	case A:
-		record, err := readARecord(buffer, domain, ttl)
-		if err != nil {
-			return UnknownRecord{}, err
-		}
-		return record, nil
-	case AAAA:
-		record, err := readAaaaRecord(buffer, domain, ttl)
-		if err != nil {
-			return UnknownRecord{}, err
-		}
-		return record, nil
-	case NS:
-		record, err := readNsRecord(buffer, domain, ttl)
+	if err := buffer.writeU16(record.priority); err != nil {
+		return 0, err
+	}
+
+	// RFC 9460 forbids compressing the target, and SVCB is not among the
+	// types whose names canonical form lowercases (RFC 4034 section 6.2)
+	if err := buffer.writeExactQName(record.target); err != nil {
+		return 0, err
+	}
+
+	for _, param := range params {
+		value, err := param.pack()
 		if err != nil {
-			return UnknownRecord{}, err
+			return 0, err
 		}
-		return record, nil
-	case CNAME:
-		record, err := readCNameRecord(buffer, domain, ttl)
-		if err != nil {
-			return UnknownRecord{}, err
+
+		if err := buffer.writeU16(uint16(param.Key())); err != nil {
+			return 0, err
 		}
-		return record, nil
-	case MX:
-		record, err := readMxRecord(buffer, domain, ttl)
-		if err != nil {
-			return UnknownRecord{}, err
+
+		if err := buffer.writeU16(uint16(len(value))); err != nil {
+			return 0, err
 		}
-		return record, nil
-	default:
-		if err := buffer.Step(uint32(dataLen)); err != nil {
-			return &UnknownRecord{}, err
+
+		if err := buffer.writeBytes(value); err != nil {
+			return 0, err
 		}
+	}
 
-		return &UnknownRecord{domain, qtype, dataLen, ttl}, nil
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
 	}
+
+	return buffer.Pos() - startPos, nil
 }
 
-// Write writes this record to a buffer
-func (record ARecord) Write(buffer *BytePacketBuffer) (uint32, error) {
-	startPos := buffer.Pos()
-	if err := buffer.writeQName(record.domain); err != nil {
-		return 0, err
+func (record SvcbRecord) String() string {
+	params := make([]string, len(record.params))
+	for idx, param := range record.params {
+		params[idx] = fmt.Sprint(param)
+	}
+
+	target := record.target
+	if len(target) == 0 {
+		target = "."
 	}
 
-	if err := buffer.writeU16(uint16(A)); err != nil {
+	return fmt.Sprintf("domain: %s, priority: %d, target: %s, params: %s, ttl: %d, class: %s",
+		record.domain, record.priority, target, strings.Join(params, " "), record.ttl, record.class)
+}
+
+func (record DsRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
+	if err != nil {
 		return 0, err
 	}
 
-	// TODO class
-	if err := buffer.writeU16(1); err != nil {
+	if err := buffer.writeU16(record.keyTag); err != nil {
 		return 0, err
 	}
 
-	if err := buffer.writeU32(record.ttl); err != nil {
+	if err := buffer.writeBytes([]byte{record.algorithm, record.digestType}); err != nil {
 		return 0, err
 	}
 
-	// Length
-	if err := buffer.writeU16(4); err != nil {
+	if err := buffer.writeBytes(record.digest); err != nil {
 		return 0, err
 	}
 
-	for _, octet := range record.addr {
-		if err := buffer.write(octet); err != nil {
-			return 0, err
-		}
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
 	}
 
 	return buffer.Pos() - startPos, nil
 }
 
-func (record AaaaRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+func (record DsRecord) String() string {
+	return fmt.Sprintf("domain: %s, keyTag: %d, algorithm: %d, digestType: %d, digest: %X, ttl: %d, class: %s",
+		record.domain, record.keyTag, record.algorithm, record.digestType, record.digest, record.ttl, record.class)
+}
+
+func (record RrsigRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
 	startPos := buffer.Pos()
-	if err := buffer.writeQName(record.domain); err != nil {
+	pos, err := record.Header().write(buffer)
+	if err != nil {
 		return 0, err
 	}
 
-	if err := buffer.writeU16(uint16(AAAA)); err != nil {
+	if err := record.writeSignedFields(buffer); err != nil {
 		return 0, err
 	}
 
-	// TODO class
-	if err := buffer.writeU16(1); err != nil {
+	if err := buffer.writeBytes(record.signature); err != nil {
 		return 0, err
 	}
 
-	if err := buffer.writeU32(record.ttl); err != nil {
+	if err := finishRData(buffer, pos); err != nil {
 		return 0, err
 	}
 
-	// Length
-	if err := buffer.writeU16(16); err != nil {
-		return 0, err
+	return buffer.Pos() - startPos, nil
+}
+
+// writeSignedFields writes the RRSIG RDATA up to and including the signer's
+// name, which is the part the signature itself covers
+func (record RrsigRecord) writeSignedFields(buffer *BytePacketBuffer) error {
+	if err := buffer.writeU16(uint16(record.typeCovered)); err != nil {
+		return err
 	}
 
-	for _, octet := range record.addr {
-		if err := buffer.write(octet); err != nil {
-			return 0, err
+	if err := buffer.writeBytes([]byte{record.algorithm, record.labels}); err != nil {
+		return err
+	}
+
+	for _, field := range []uint32{record.originalTTL, record.expiration, record.inception} {
+		if err := buffer.writeU32(field); err != nil {
+			return err
 		}
 	}
 
-	return buffer.Pos() - startPos, nil
+	if err := buffer.writeU16(record.keyTag); err != nil {
+		return err
+	}
+
+	// RFC 4034 forbids compressing the signer's name
+	return buffer.writeUncompressedQName(record.signerName)
 }
 
-func (record NsRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+func (record RrsigRecord) String() string {
+	return fmt.Sprintf("domain: %s, typeCovered: %s, algorithm: %d, labels: %d, originalTTL: %d, expiration: %s, inception: %s, keyTag: %d, signer: %s, ttl: %d, class: %s",
+		record.domain, record.typeCovered, record.algorithm, record.labels, record.originalTTL,
+		formatSigTime(record.expiration), formatSigTime(record.inception), record.keyTag, record.signerName, record.ttl, record.class)
+}
+
+// formatSigTime formats an RRSIG timestamp as YYYYMMDDHHmmSS
+func formatSigTime(timestamp uint32) string {
+	return time.Unix(int64(timestamp), 0).UTC().Format("20060102150405")
+}
+
+func (record NsecRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
 	startPos := buffer.Pos()
-	if err := buffer.writeQName(record.domain); err != nil {
+	pos, err := record.Header().write(buffer)
+	if err != nil {
 		return 0, err
 	}
 
-	if err := buffer.writeU16(uint16(NS)); err != nil {
+	// RFC 4034 forbids compressing the next domain name, and RFC 6840 lowercasing it
+	if err := buffer.writeExactQName(record.nextDomain); err != nil {
 		return 0, err
 	}
 
-	// Class
-	if err := buffer.writeU16(1); err != nil {
+	if err := buffer.writeBytes(packTypeBitmap(record.types)); err != nil {
 		return 0, err
 	}
 
-	if err := buffer.writeU32(record.ttl); err != nil {
+	if err := finishRData(buffer, pos); err != nil {
 		return 0, err
 	}
 
-	pos := buffer.Pos()
-	if err := buffer.writeU16(0); err != nil {
+	return buffer.Pos() - startPos, nil
+}
+
+func (record NsecRecord) String() string {
+	return fmt.Sprintf("domain: %s, next: %s, types: %s, ttl: %d, class: %s", record.domain, record.nextDomain, formatTypes(record.types), record.ttl, record.class)
+}
+
+// formatTypes lists types the way NSEC and NSEC3 records show them in zone files
+func formatTypes(types []QueryType) string {
+	names := make([]string, len(types))
+	for idx, qtype := range types {
+		names[idx] = qtype.String()
+		if names[idx] == "UNKNOWN" {
+			names[idx] = fmt.Sprintf("TYPE%d", int(qtype))
+		}
+	}
+
+	return strings.Join(names, " ")
+}
+
+func (record DnskeyRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
+	if err != nil {
 		return 0, err
 	}
 
-	if err := buffer.writeQName(record.host); err != nil {
+	if err := record.writeRData(buffer); err != nil {
 		return 0, err
 	}
-	size := buffer.Pos() - pos - 2
-	if err := buffer.SetU16(pos, uint16(size)); err != nil {
+
+	if err := finishRData(buffer, pos); err != nil {
 		return 0, err
 	}
 
 	return buffer.Pos() - startPos, nil
 }
 
-func (record CNameRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+func (record DnskeyRecord) writeRData(buffer *BytePacketBuffer) error {
+	if err := buffer.writeU16(record.flags); err != nil {
+		return err
+	}
+
+	if err := buffer.writeBytes([]byte{record.protocol, record.algorithm}); err != nil {
+		return err
+	}
+
+	return buffer.writeBytes(record.publicKey)
+}
+
+// KeyTag computes the tag RRSIG and DS records use to refer to this key
+// (RFC 4034 appendix B)
+func (record DnskeyRecord) KeyTag() uint16 {
+	rdata := NewBytePacketBuffer(0)
+	record.writeRData(rdata)
+
+	sum := uint32(0)
+	for idx, b := range rdata.Bytes() {
+		if idx&1 == 0 {
+			sum += uint32(b) << 8
+		} else {
+			sum += uint32(b)
+		}
+	}
+	sum += sum >> 16 & 0xFFFF
+
+	return uint16(sum & 0xFFFF)
+}
+
+// IsZoneKey reports whether the Zone Key flag is set, the only kind of key
+// that may sign zone data
+func (record DnskeyRecord) IsZoneKey() bool {
+	return record.flags&0x0100 != 0
+}
+
+// IsSecureEntryPoint reports whether the SEP flag marks this as a key signing key
+func (record DnskeyRecord) IsSecureEntryPoint() bool {
+	return record.flags&0x0001 != 0
+}
+
+// IsRevoked reports whether the REVOKE flag is set (RFC 5011)
+func (record DnskeyRecord) IsRevoked() bool {
+	return record.flags&0x0080 != 0
+}
+
+func (record DnskeyRecord) String() string {
+	return fmt.Sprintf("domain: %s, flags: %d, protocol: %d, algorithm: %d, keyTag: %d, key: %s, ttl: %d, class: %s",
+		record.domain, record.flags, record.protocol, record.algorithm, record.KeyTag(), base64.StdEncoding.EncodeToString(record.publicKey), record.ttl, record.class)
+}
+
+func (record Nsec3Record) Write(buffer *BytePacketBuffer) (uint32, error) {
 	startPos := buffer.Pos()
-	if err := buffer.writeQName(record.domain); err != nil {
+	pos, err := record.Header().write(buffer)
+	if err != nil {
 		return 0, err
 	}
 
-	if err := buffer.writeU16(uint16(CNAME)); err != nil {
+	if err := buffer.writeBytes([]byte{record.hashAlgorithm, record.flags}); err != nil {
 		return 0, err
 	}
 
-	// Class
-	if err := buffer.writeU16(1); err != nil {
+	if err := buffer.writeU16(record.iterations); err != nil {
 		return 0, err
 	}
 
-	if err := buffer.writeU32(record.ttl); err != nil {
+	if err := buffer.writeCharacterString(string(record.salt)); err != nil {
 		return 0, err
 	}
 
-	pos := buffer.Pos()
-	if err := buffer.writeU16(0); err != nil {
+	if err := buffer.writeCharacterString(string(record.nextHashed)); err != nil {
 		return 0, err
 	}
 
-	if err := buffer.writeQName(record.host); err != nil {
+	if err := buffer.writeBytes(packTypeBitmap(record.types)); err != nil {
 		return 0, err
 	}
-	size := buffer.Pos() - pos - 2
-	if err := buffer.SetU16(pos, uint16(size)); err != nil {
+
+	if err := finishRData(buffer, pos); err != nil {
 		return 0, err
 	}
 
 	return buffer.Pos() - startPos, nil
 }
 
-func (record MxRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+// OptOut reports whether this record may cover unsigned delegations (RFC 5155 section 6)
+func (record Nsec3Record) OptOut() bool {
+	return record.flags&0x01 != 0
+}
+
+func (record Nsec3Record) String() string {
+	salt := "-"
+	if len(record.salt) > 0 {
+		salt = fmt.Sprintf("%X", record.salt)
+	}
+
+	return fmt.Sprintf("domain: %s, hash: %d, flags: %d, iterations: %d, salt: %s, next: %s, types: %s, ttl: %d, class: %s",
+		record.domain, record.hashAlgorithm, record.flags, record.iterations, salt, base32Hex.EncodeToString(record.nextHashed), formatTypes(record.types), record.ttl, record.class)
+}
+
+// base32Hex is the unpadded "Extended Hex" alphabet NSEC3 uses for hashed names
+var base32Hex = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+func (record OptRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
 	startPos := buffer.Pos()
-	if err := buffer.writeQName(record.domain); err != nil {
+	// The owner is always the root domain
+	if err := buffer.write(0); err != nil {
 		return 0, err
 	}
 
-	if err := buffer.writeU16(uint16(MX)); err != nil {
+	if err := buffer.writeU16(uint16(OPT)); err != nil {
 		return 0, err
 	}
 
-	// Class
-	if err := buffer.writeU16(1); err != nil {
+	if err := buffer.writeU16(record.udpSize); err != nil {
 		return 0, err
 	}
 
-	if err := buffer.writeU32(record.ttl); err != nil {
+	flags := uint32(record.extendedRcode)<<24 | uint32(record.version)<<16
+	if record.dnssecOk {
+		flags |= 1 << 15
+	}
+	if err := buffer.writeU32(flags); err != nil {
 		return 0, err
 	}
 
@@ -366,12 +1927,20 @@ func (record MxRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
 		return 0, err
 	}
 
-	if err := buffer.writeU16(record.priority); err != nil {
-		return 0, err
-	}
+	for _, option := range record.options {
+		if err := buffer.writeU16(option.code); err != nil {
+			return 0, err
+		}
 
-	if err := buffer.writeQName(record.host); err != nil {
-		return 0, err
+		if err := buffer.writeU16(uint16(len(option.data))); err != nil {
+			return 0, err
+		}
+
+		for _, b := range option.data {
+			if err := buffer.write(b); err != nil {
+				return 0, err
+			}
+		}
 	}
 	size := buffer.Pos() - pos - 2
 	if err := buffer.SetU16(pos, uint16(size)); err != nil {
@@ -381,9 +1950,31 @@ func (record MxRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
 	return buffer.Pos() - startPos, nil
 }
 
-// Write writes this record to a buffer
-func (record UnknownRecord) Write(_ *BytePacketBuffer) (uint32, error) {
-	fmt.Printf("Skipping UNKNOWN record %s\n", record.domain)
+func (record OptRecord) String() string {
+	return fmt.Sprintf("udp: %d, version: %d, do: %t, options: %d", record.udpSize, record.version, record.dnssecOk, len(record.options))
+}
+
+// Write writes this record to a buffer, copying the RDATA back out untouched
+func (record UnknownRecord) Write(buffer *BytePacketBuffer) (uint32, error) {
+	startPos := buffer.Pos()
+	pos, err := record.Header().write(buffer)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, b := range record.data {
+		if err := buffer.write(b); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := finishRData(buffer, pos); err != nil {
+		return 0, err
+	}
+
+	return buffer.Pos() - startPos, nil
+}
 
-	return 0, nil
+func (record UnknownRecord) String() string {
+	return fmt.Sprintf("domain: %s, type: %d, data: %x, ttl: %d, class: %s", record.domain, record.qtype, record.data, record.ttl, record.class)
 }
@@ -1,5 +1,7 @@
 package main
 
+import "fmt"
+
 // ResultCode for DNS looking
 type ResultCode int
 
@@ -11,8 +13,14 @@ const (
 	NXDOMAIN
 	NOTIMP
 	REFUSED
+	YXDOMAIN
 )
 
 func (resultCode ResultCode) String() string {
-	return [...]string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}[resultCode]
+	names := [...]string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED", "YXDOMAIN"}
+	if resultCode < 0 || int(resultCode) >= len(names) {
+		return fmt.Sprintf("RCODE%d", int(resultCode))
+	}
+
+	return names[resultCode]
 }
@@ -1,11 +1,50 @@
 package main
 
 import (
+	cryptorand "crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"log"
+	"math/rand"
 	"net"
+	"os"
+	"time"
 )
 
+const (
+	listenAddr = "127.0.0.1:8080"
+
+	// lookupTimeout bounds a single exchange with an upstream server
+	lookupTimeout = 5 * time.Second
+	// tcpIdleTimeout is how long a client connection may sit between queries
+	tcpIdleTimeout = 10 * time.Second
+
+	// ednsUDPSize is the UDP payload size we advertise in OPT records. 1232
+	// bytes avoids IP fragmentation on nearly every path.
+	ednsUDPSize = 1232
+	// maxReferrals caps how many delegations one lookup follows down the tree
+	maxReferrals = 32
+
+	// badVersion is the extended RCODE for an unsupported EDNS version
+	badVersion = 16
+
+	// serverVersion is what version.bind and version.server report
+	serverVersion = "simple-dns"
+)
+
+func newQuery(qname string, qtype QueryType) Packet {
+	// A random ID makes forged responses much harder to get accepted
+	var id [2]byte
+	cryptorand.Read(id[:])
+	header := Header{id: binary.BigEndian.Uint16(id[:]), questions: 1, recursionDesired: true}
+	question := Question{name: qname, qType: qtype, class: IN}
+	questions := make([]Question, 1)
+	questions[0] = question
+	// The DO bit asks for the RRSIGs and NSEC records validation needs
+	resources := []Record{OptRecord{udpSize: ednsUDPSize, dnssecOk: true}}
+	return Packet{header: header, questions: questions, resources: resources}
+}
+
 func lookup(qname string, qtype QueryType, host string, port uint16) (Packet, error) {
 	raddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", host, port))
 	if err != nil {
@@ -16,35 +55,252 @@ func lookup(qname string, qtype QueryType, host string, port uint16) (Packet, er
 		return Packet{}, err
 	}
 	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(lookupTimeout))
 
-	header := Header{id: 6666, questions: 1, recursionDesired: true}
-	question := Question{name: qname, qType: qtype}
-	questions := make([]Question, 1)
-	questions[0] = question
-	packet := Packet{header: header, questions: questions}
+	packet := newQuery(qname, qtype)
+	reqBuffer := NewBytePacketBuffer(MaxUDPSize)
+	if err := packet.Write(reqBuffer); err != nil {
+		return Packet{}, err
+	}
+
+	if _, err := conn.Write(reqBuffer.Bytes()); err != nil {
+		return Packet{}, err
+	}
+
+	// Anything that is not the answer to our query is ignored until the deadline
+	data := make([]byte, ednsUDPSize)
+	for {
+		size, err := conn.Read(data)
+		if err != nil {
+			return Packet{}, err
+		}
+
+		response, err := Read(NewBytePacketBufferFrom(data[:size]))
+		if err == nil && matchesQuery(packet, response) {
+			return response, nil
+		}
+		fmt.Printf("Ignoring response from %s that does not match the query\n", host)
+	}
+}
+
+func lookupTCP(qname string, qtype QueryType, host string, port uint16) (Packet, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, fmt.Sprint(port)), lookupTimeout)
+	if err != nil {
+		return Packet{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(lookupTimeout))
+
+	packet := newQuery(qname, qtype)
+	reqBuffer := NewBytePacketBuffer(MaxMessageSize)
+	if err := packet.Write(reqBuffer); err != nil {
+		return Packet{}, err
+	}
 
-	reqBuffer := BytePacketBuffer{}
-	if err := packet.Write(&reqBuffer); err != nil {
-		return Packet{}, nil
+	if err := writeTCPMessage(conn, reqBuffer.Bytes()); err != nil {
+		return Packet{}, err
 	}
 
-	if _, err := conn.Write(reqBuffer.buf[:reqBuffer.Pos()]); err != nil {
-		return Packet{}, nil
+	data, err := readTCPMessage(conn)
+	if err != nil {
+		return Packet{}, err
+	}
+
+	response, err := Read(NewBytePacketBufferFrom(data))
+	if err != nil {
+		return Packet{}, err
 	}
 
-	resBuffer := BytePacketBuffer{}
-	if _, err := conn.Read(resBuffer.buf[:]); err != nil {
-		return Packet{}, nil
+	if !matchesQuery(packet, response) {
+		return Packet{}, InvalidInput(fmt.Sprintf("Response from %s does not match the query", host))
 	}
 
-	return Read(&reqBuffer)
+	return response, nil
 }
 
+// matchesQuery reports whether response answers query: it must carry the
+// same ID and the same question
+func matchesQuery(query Packet, response Packet) bool {
+	if !response.header.response || response.header.id != query.header.id || len(response.questions) != 1 {
+		return false
+	}
+
+	asked, answered := query.questions[0], response.questions[0]
+	return normalizeName(asked.name) == normalizeName(answered.name) && asked.qType == answered.qType && asked.class == answered.class
+}
+
+// cache is shared by every query the server resolves
+var cache = NewCache()
+
+// cacheResponse stores the records of an upstream response that fall inside
+// the zone the answering server was asked about, so a server for one zone
+// cannot plant records for another.
+func cacheResponse(response Packet, zone string) {
+	for _, section := range [][]Record{response.answers, response.authorities, response.resources} {
+		inZone := make([]Record, 0, len(section))
+		for _, record := range section {
+			if isSubdomain(record.Header().domain, zone) {
+				inZone = append(inZone, record)
+			}
+		}
+		cache.PutRecords(clampToSignatures(inZone))
+	}
+}
+
+// cacheNegative remembers an NXDOMAIN or NODATA response from a server for
+// zone. The denial is about the name any alias chain ends at, and only counts
+// when its SOA is inside zone and encloses that name.
+func cacheNegative(response Packet, qname string, qtype QueryType, zone string) {
+	target := response.chainTarget(qname)
+	authorities := make([]Record, 0, len(response.authorities))
+	for _, record := range response.authorities {
+		if isSubdomain(record.Header().domain, zone) {
+			authorities = append(authorities, record)
+		}
+	}
+
+	for _, record := range authorities {
+		if soaRecord, ok := record.(SoaRecord); ok && isSubdomain(target, soaRecord.domain) {
+			cache.PutNegative(target, qtype, IN, response.header.rescode, authorities)
+			return
+		}
+	}
+}
+
+// cachedResponse builds a response to qname from records served by the cache
+func cachedResponse(qname string, qtype QueryType, rescode ResultCode, answers []Record, authorities []Record) Packet {
+	header := Header{response: true, rescode: rescode}
+	questions := []Question{{name: qname, qType: qtype, class: IN}}
+	return Packet{header: header, questions: questions, answers: answers, authorities: authorities}
+}
+
+// recursiveLookup resolves qname and follows any CNAME chain in the answer,
+// across zones if need be. The response carries the whole chain followed by
+// the final RRset, with the result code and authorities of the last step.
 func recursiveLookup(qname string, qtype QueryType) (Packet, error) {
-	// Always start with *a.root-servers.net*
-	ns := "198.41.0.4"
+	response, err := resolveName(qname, qtype)
+	if err != nil || qtype == CNAME {
+		return response, err
+	}
 
+	answers := make([]Record, len(response.answers))
+	copy(answers, response.answers)
+	name := normalizeName(qname)
+	seen := map[string]bool{name: true}
+	chased := map[string]bool{name: true}
 	for {
+		chain := Packet{answers: answers}
+		for {
+			cnameRecord, ok := chain.getCName(name)
+			if !ok {
+				break
+			}
+
+			name = normalizeName(cnameRecord.host)
+			if seen[name] || len(seen) > maxChainLength {
+				fmt.Printf("CNAME chain for %s loops or is too long\n", qname)
+				response.header.rescode = SERVFAIL
+				response.answers = answers
+				return response, nil
+			}
+			seen[name] = true
+		}
+
+		if chased[name] || chain.hasRRset(name, qtype) {
+			break
+		}
+		chased[name] = true
+
+		next, err := resolveName(name, qtype)
+		if err != nil {
+			return next, err
+		}
+
+		// The answer is only authoritative while every hop comes from our zones
+		answers = append(answers, next.answers...)
+		response.header.authoritativeAnswer = response.header.authoritativeAnswer && next.header.authoritativeAnswer
+		response.header.rescode = next.header.rescode
+		response.authorities = next.authorities
+		response.resources = next.resources
+	}
+
+	response.answers = answers
+	return response, nil
+}
+
+// resolveName resolves a single name from the cache or by walking down from
+// the closest known zone cut, without following any CNAME it runs into.
+func resolveName(qname string, qtype QueryType) (Packet, error) {
+	// Our own zones answer for themselves, and a referral out of one of them
+	// is where the walk down starts
+	referral := Packet{}
+	if authZone := findAuthZone(qname, qtype); authZone != nil {
+		response, err := authZone.Answer(qname, qtype, true)
+		if err != nil || response.header.authoritativeAnswer {
+			return response, err
+		}
+		referral = response
+	}
+
+	if answers, ok := cache.Get(qname, qtype, IN); ok {
+		fmt.Printf("Cache hit for %s %s\n", qtype, qname)
+		return cachedResponse(qname, qtype, NOERROR, answers, nil), nil
+	}
+
+	if answers, ok := cache.Get(qname, CNAME, IN); ok {
+		fmt.Printf("Cache hit for CNAME %s\n", qname)
+		return cachedResponse(qname, qtype, NOERROR, answers, nil), nil
+	}
+
+	if rescode, authorities, ok := cache.GetNegative(qname, qtype, IN); ok {
+		fmt.Printf("Negative cache hit for %s %s\n", qtype, qname)
+		return cachedResponse(qname, qtype, rescode, nil, authorities), nil
+	}
+
+	// A cached DNAME above qname redirects it without asking anyone
+	if dnameRecord, ok := cache.GetDname(qname); ok && qtype != DNAME {
+		fmt.Printf("Cache hit for DNAME %s\n", dnameRecord.domain)
+		response := cachedResponse(qname, qtype, NOERROR, []Record{dnameRecord}, nil)
+		if !response.SynthesizeCNames(qname) {
+			response.header.rescode = YXDOMAIN
+		}
+		return response, nil
+	}
+
+	// Start at the closest zone cut we know about, or else *a.root-servers.net*.
+	// DS records live on the parent side of a cut, so look above qname for them.
+	ns := "198.41.0.4"
+	cut := qname
+	if qtype == DS {
+		cut = parentName(normalizeName(qname))
+	}
+	zone, servers := cache.GetNameservers(cut)
+	if len(servers) > 0 {
+		ns = servers[rand.Intn(len(servers))]
+	}
+
+	if referralZone := referral.GetReferralZone(qname); len(referralZone) > 0 && isSubdomain(referralZone, zone) {
+		zone = referralZone
+		if newNs := referral.GetResolvedNs(qname); len(newNs) > 0 {
+			ns = newNs
+		} else if newNsName := referral.GetUnresolvedNs(qname); len(newNsName) > 0 {
+			recursiveResponse, err := recursiveLookup(newNsName, A)
+			if err != nil {
+				return recursiveResponse, err
+			}
+
+			if newNs := recursiveResponse.GetRandomARecord(); len(newNs) > 0 {
+				ns = newNs
+			}
+		}
+	}
+
+	for referrals := 0; ; referrals++ {
+		if referrals > maxReferrals {
+			fmt.Printf("Too many referrals for %s %s\n", qtype, qname)
+			return servfail(qname, qtype), nil
+		}
+
 		fmt.Printf("Attempting lookup of %s %s with ns %s", qtype, qname, ns)
 
 		nsCopy := ns
@@ -53,20 +309,51 @@ func recursiveLookup(qname string, qtype QueryType) (Packet, error) {
 			return response, err
 		}
 
+		// A truncated response is incomplete, so ask the same server again over TCP
+		if response.header.truncatedMessage {
+			fmt.Printf("Truncated response from %s, retrying over TCP\n", nsCopy)
+			response, err = lookupTCP(qname, qtype, nsCopy, 53)
+			if err != nil {
+				return response, err
+			}
+		}
+
+		if !response.SynthesizeCNames(qname) {
+			response.header.rescode = YXDOMAIN
+			return response, nil
+		}
+
+		cacheResponse(response, zone)
+
 		if len(response.answers) > 0 && response.header.rescode == NOERROR {
 			return response, nil
 		}
 
-		if response.header.rescode == NXDOMAIN {
+		if response.header.rescode == NXDOMAIN || response.IsNoData() {
+			cacheNegative(response, qname, qtype, zone)
 			return response, nil
 		}
 
-		if newNs := response.GetResolvedNs(qname); len(newNs) > 0 {
+		referral := response.GetReferralZone(qname)
+		if len(referral) == 0 {
+			return response, nil
+		}
+
+		// Only follow referrals further down the tree, so zone always names
+		// the zone of the server being asked
+		if !isSubdomain(referral, zone) || normalizeName(referral) == normalizeName(zone) {
+			fmt.Printf("Ignoring referral from %s to %q, which is not below %q\n", nsCopy, referral, zone)
+			return servfail(qname, qtype), nil
+		}
+		zone = referral
+		delegation := response.delegation(referral)
+
+		if newNs := delegation.GetResolvedNs(qname); len(newNs) > 0 {
 			ns = newNs
 			continue
 		}
 
-		newNsName := response.GetUnresolvedNs(qname)
+		newNsName := delegation.GetUnresolvedNs(qname)
 		if len(newNsName) == 0 {
 			return response, nil
 		}
@@ -84,8 +371,184 @@ func recursiveLookup(qname string, qtype QueryType) (Packet, error) {
 	}
 }
 
+// servfail is the answer for a lookup that could not be completed
+func servfail(qname string, qtype QueryType) Packet {
+	response := Packet{header: Header{response: true, rescode: SERVFAIL}}
+	response.questions = []Question{{name: qname, qType: qtype, class: IN}}
+	return response
+}
+
+// reverseLookup resolves the PTR records for an address
+func reverseLookup(ip net.IP) (Packet, error) {
+	qname := reverseName(ip)
+	if len(qname) == 0 {
+		return Packet{}, InvalidInput(fmt.Sprintf("Invalid IP address %s", ip))
+	}
+
+	return recursiveLookup(qname, PTR)
+}
+
+// udpLimit returns the size a UDP response to request may use, honoring the
+// payload size the client advertised in its OPT record.
+func udpLimit(request Packet) uint32 {
+	opt, ok := request.GetOpt()
+	if !ok || opt.udpSize < MaxUDPSize {
+		return MaxUDPSize
+	}
+
+	if opt.udpSize > ednsUDPSize {
+		return ednsUDPSize
+	}
+
+	return uint32(opt.udpSize)
+}
+
+// withoutDnssec drops the DNSSEC records a client that did not set the DO
+// bit has no use for, unless it asked for that type (RFC 3225 section 3)
+func withoutDnssec(records []Record, qtype QueryType) []Record {
+	filtered := make([]Record, 0, len(records))
+	for _, record := range records {
+		switch record.Header().qtype {
+		case RRSIG, NSEC, NSEC3:
+			if record.Header().qtype != qtype {
+				continue
+			}
+		}
+		filtered = append(filtered, record)
+	}
+
+	return filtered
+}
+
+// chaosAnswer answers the CHAOS class TXT queries that identify a server
+// (RFC 4892) and refuses any other CHAOS name
+func chaosAnswer(question Question) ([]Record, ResultCode) {
+	var text string
+	switch normalizeName(question.name) {
+	case "id.server", "hostname.bind":
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, SERVFAIL
+		}
+		text = hostname
+	case "version.server", "version.bind":
+		text = serverVersion
+	default:
+		return nil, REFUSED
+	}
+
+	if question.qType != TXT {
+		return nil, NOERROR
+	}
+
+	return []Record{TxtRecord{question.name, []string{text}, 0, CH}}, NOERROR
+}
+
+func handleQuery(request Packet) Packet {
+	packet := Packet{}
+	header := Header{id: request.header.id, recursionDesired: true, recursionAvailable: true, response: true}
+	opt, hasOpt := request.GetOpt()
+	responseOpt := OptRecord{udpSize: ednsUDPSize, dnssecOk: opt.dnssecOk}
+
+	if len(request.questions) == 0 {
+		header.rescode = FORMERR
+	} else if request.questions[0].class == CH {
+		fmt.Printf("Received query: %s\n", request.questions[0])
+		answers, rescode := chaosAnswer(request.questions[0])
+		header.rescode = rescode
+		header.authoritativeAnswer = rescode == NOERROR
+		packet.questions = []Question{request.questions[0]}
+		packet.answers = answers
+	} else if request.questions[0].class != IN {
+		// Recursion only makes sense for the Internet class
+		header.rescode = NOTIMP
+	} else if hasOpt && opt.version > 0 {
+		// Only the upper eight bits of the twelve bit RCODE live in the OPT record
+		responseOpt.extendedRcode = badVersion >> 4
+	} else {
+		question := request.questions[0]
+		fmt.Printf("Received query: %s\n", question)
+
+		// Without recursion desired, names in our zones get just what the zone holds
+		var result Packet
+		var err error
+		if authZone := findAuthZone(question.name, question.qType); authZone != nil && !request.header.recursionDesired {
+			result, err = authZone.Answer(question.name, question.qType, opt.dnssecOk)
+		} else {
+			result, err = recursiveLookup(question.name, question.qType)
+		}
+
+		// Checking Disabled asks for the data even if it does not validate
+		status := Insecure
+		if err == nil && !request.header.checkingDisabled {
+			status = validateResponse(question, result)
+		}
+
+		if err != nil {
+			header.rescode = SERVFAIL
+		} else if status == Bogus {
+			fmt.Printf("Response for %s is bogus\n", question)
+			header.rescode = SERVFAIL
+		} else {
+			questions := make([]Question, len(request.questions))
+			copy(questions, request.questions)
+			header.rescode = result.header.rescode
+			header.authoritativeAnswer = result.header.authoritativeAnswer
+			header.checkingDisabled = request.header.checkingDisabled
+			// Only clients that show they understand the AD bit get it (RFC 6840 section 5.8)
+			header.authedData = status == Secure && (opt.dnssecOk || request.header.authedData)
+
+			if !opt.dnssecOk {
+				result.answers = withoutDnssec(result.answers, question.qType)
+				result.authorities = withoutDnssec(result.authorities, question.qType)
+				result.resources = withoutDnssec(result.resources, question.qType)
+			}
+
+			answers := make([]Record, len(result.answers))
+			for idx, record := range result.answers {
+				fmt.Printf("Answer: %s\n", record)
+				answers[idx] = record
+			}
+
+			authorities := make([]Record, len(result.authorities))
+			for idx, record := range result.authorities {
+				fmt.Printf("Authority: %s\n", record)
+				authorities[idx] = record
+			}
+
+			resources := make([]Record, 0, len(result.resources))
+			for _, record := range result.resources {
+				// The upstream OPT record only applied to that hop
+				if _, ok := record.(OptRecord); ok {
+					continue
+				}
+				fmt.Printf("Resource: %s\n", record)
+				resources = append(resources, record)
+			}
+
+			packet.answers = answers
+			packet.authorities = authorities
+			packet.resources = resources
+			packet.questions = questions
+		}
+	}
+
+	if hasOpt {
+		packet.resources = append(packet.resources, responseOpt)
+	}
+
+	packet.header = header
+	return packet
+}
+
 func start() {
-	laddr, err := net.ResolveUDPAddr("udp", "127.0.0.1:8080")
+	go maintainTrustAnchors()
+	go serveTCP()
+	serveUDP()
+}
+
+func serveUDP() {
+	laddr, err := net.ResolveUDPAddr("udp", listenAddr)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -97,82 +560,106 @@ func start() {
 	defer conn.Close()
 
 	for {
-		reqBuffer := BytePacketBuffer{}
+		reqData := make([]byte, MaxMessageSize)
 		fmt.Println("Waiting for message...")
-		if _, err := conn.Read(reqBuffer.buf[:]); err != nil {
+		size, raddr, err := conn.ReadFromUDP(reqData)
+		if err != nil {
 			fmt.Println("Failed to read from UDP socket.")
 			fmt.Println(err)
 			continue
 		}
 
-		request, err := Read(&reqBuffer)
+		request, err := Read(NewBytePacketBufferFrom(reqData[:size]))
 		if err != nil {
 			fmt.Println("Failed to parse UDP query packet.")
 			fmt.Println(err)
 		}
 
-		packet := Packet{}
-		header := Header{id: request.header.id, recursionDesired: true, recursionAvailable: true, response: true}
-
-		if len(request.questions) == 0 {
-			header.rescode = FORMERR
-		} else {
-			question := request.questions[0]
-			fmt.Printf("Received query: %s\n", question)
-			if result, err := recursiveLookup(question.name, question.qType); err != nil {
-				header.rescode = SERVFAIL
-			} else {
-				questions := make([]Question, len(request.questions))
-				copy(questions, request.questions)
-				header.rescode = result.header.rescode
-
-				answers := make([]Record, len(request.answers))
-				for idx, record := range result.answers {
-					fmt.Printf("Answer: %s\n", record)
-					answers[idx] = record
-				}
+		packet := handleQuery(request)
 
-				authorities := make([]Record, len(request.authorities))
-				for idx, record := range result.authorities {
-					fmt.Printf("Authority: %s\n", record)
-					authorities[idx] = record
-				}
-
-				resources := make([]Record, len(request.resources))
-				for idx, record := range result.resources {
-					fmt.Printf("Resource: %s\n", record)
-					resources[idx] = record
-				}
+		resBuffer, err := packet.WriteTruncated(udpLimit(request))
+		if err != nil {
+			fmt.Println("Failed to encode UDP response packet.")
+			fmt.Println(err)
+			continue
+		}
 
-				packet.answers = answers
-				packet.authorities = authorities
-				packet.resources = resources
-				packet.questions = questions
-			}
+		fmt.Println("Sending response...")
+		if _, err := conn.WriteToUDP(resBuffer.Bytes(), raddr); err != nil {
+			fmt.Println("Failed to send response buffer")
+			fmt.Println(err)
+			continue
 		}
+	}
+}
 
-		packet.header = header
+// failureResponse is a bare SERVFAIL for request, for when its real response
+// cannot be encoded
+func failureResponse(request Packet) Packet {
+	header := Header{id: request.header.id, recursionDesired: request.header.recursionDesired, recursionAvailable: true, response: true, rescode: SERVFAIL}
+	questions := make([]Question, len(request.questions))
+	copy(questions, request.questions)
+	return Packet{header: header, questions: questions}
+}
 
-		resBuffer := BytePacketBuffer{}
-		if err := packet.Write(&resBuffer); err != nil {
-			fmt.Println("Failed to encode UDP response packet.")
+func serveTCP() {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Println("Failed to accept TCP connection.")
 			fmt.Println(err)
 			continue
 		}
 
-		len := resBuffer.Pos()
-		data, err := resBuffer.GetRange(0, len)
+		go handleTCPConn(conn)
+	}
+}
+
+// handleTCPConn answers queries on one connection until the client closes it
+// or stays idle for longer than tcpIdleTimeout.
+func handleTCPConn(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		reqData, err := readTCPMessage(conn)
+		if err != nil {
+			return
+		}
+
+		request, err := Read(NewBytePacketBufferFrom(reqData))
 		if err != nil {
-			fmt.Println("Failed to retrieve response buffer.")
+			fmt.Println("Failed to parse TCP query packet.")
 			fmt.Println(err)
-			continue
 		}
 
-		fmt.Println("Sending response...")
-		if _, err := conn.Write(data); err != nil {
-			fmt.Println("Failed to send response buffer")
+		packet := handleQuery(request)
+
+		resBuffer := NewBytePacketBuffer(MaxMessageSize)
+		if err := packet.Write(resBuffer); err != nil {
+			fmt.Println("Failed to encode TCP response packet.")
 			fmt.Println(err)
-			continue
+
+			// The client still gets an answer, or the connection closes
+			failure := failureResponse(request)
+			resBuffer = NewBytePacketBuffer(MaxMessageSize)
+			if err := failure.Write(resBuffer); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(tcpIdleTimeout))
+		if err := writeTCPMessage(conn, resBuffer.Bytes()); err != nil {
+			fmt.Println("Failed to send TCP response")
+			fmt.Println(err)
+			return
 		}
 	}
 }
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// signatureValidity is how long a generated RRSIG stays valid
+	signatureValidity = 7 * 24 * time.Hour
+	// signatureRefresh is how close to expiry a cached RRSIG gets replaced
+	signatureRefresh = 2 * 24 * time.Hour
+	// signatureSkew backdates inception for validators with slow clocks
+	signatureSkew = time.Hour
+	// maxSignatureEntries bounds how many RRSIGs a signer keeps
+	maxSignatureEntries = 10000
+	// dnskeyTTL is the TTL of the DNSKEY RRset a signer publishes
+	dnskeyTTL = 3600
+)
+
+// DNSKEY flags for the two roles a signing key can have
+const (
+	zoneKeyFlags    = 0x0100
+	keySigningFlags = zoneKeyFlags | 0x0001
+)
+
+// signingKey is a private key together with the DNSKEY that publishes it
+type signingKey struct {
+	dnskey  DnskeyRecord
+	private crypto.Signer
+}
+
+// loadSigningKey reads a PEM encoded ECDSA P-256 or Ed25519 private key, as
+// written by "openssl genpkey -algorithm ed25519" or "openssl ecparam
+// -name prime256v1 -genkey", and builds its DNSKEY for zone.
+func loadSigningKey(path string, zone string, flags uint16) (signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return signingKey{}, InvalidInput(fmt.Sprintf("No PEM key in %s", path))
+	}
+
+	var private any
+	if block.Type == "EC PRIVATE KEY" {
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	} else {
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	dnskey := DnskeyRecord{domain: zone, flags: flags, protocol: 3, ttl: dnskeyTTL, class: IN}
+	switch private := private.(type) {
+	case *ecdsa.PrivateKey:
+		if private.Curve != elliptic.P256() {
+			return signingKey{}, InvalidInput(fmt.Sprintf("ECDSA key in %s is not on P-256", path))
+		}
+
+		// DNSSEC wants the bare X and Y coordinates, without the 0x04 prefix
+		publicKey, err := private.PublicKey.Bytes()
+		if err != nil {
+			return signingKey{}, err
+		}
+		dnskey.algorithm = ECDSAP256SHA256
+		dnskey.publicKey = publicKey[1:]
+		return signingKey{dnskey, private}, nil
+	case ed25519.PrivateKey:
+		dnskey.algorithm = ED25519
+		dnskey.publicKey = private.Public().(ed25519.PublicKey)
+		return signingKey{dnskey, private}, nil
+	default:
+		return signingKey{}, InvalidInput(fmt.Sprintf("Key in %s is neither ECDSA P-256 nor Ed25519", path))
+	}
+}
+
+// sign signs data in the format the key's DNSSEC algorithm calls for
+func (key signingKey) sign(data []byte) ([]byte, error) {
+	switch private := key.private.(type) {
+	case *ecdsa.PrivateKey:
+		hashed := sha256.Sum256(data)
+		r, s, err := ecdsa.Sign(rand.Reader, private, hashed[:])
+		if err != nil {
+			return nil, err
+		}
+
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature, nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(private, data), nil
+	default:
+		return nil, InvalidInput("Unsupported signing key")
+	}
+}
+
+// Signer signs the answers for one zone as they are served, with a key
+// signing key over the DNSKEY RRset and a zone signing key over everything
+// else. Denial of existence uses NSEC3 white lies: records made up for each
+// query that cover just the name asked about (RFC 7129 appendix B), so the
+// zone cannot be walked.
+type Signer struct {
+	zone string
+	ksk  signingKey
+	zsk  signingKey
+
+	mutex      sync.Mutex
+	signatures map[string]RrsigRecord
+}
+
+// NewSigner loads the KSK and ZSK of zone from PEM files
+func NewSigner(zone string, kskPath string, zskPath string) (*Signer, error) {
+	zone = normalizeName(zone)
+	ksk, err := loadSigningKey(kskPath, zone, keySigningFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	zsk, err := loadSigningKey(zskPath, zone, zoneKeyFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := &Signer{zone: zone, ksk: ksk, zsk: zsk, signatures: make(map[string]RrsigRecord)}
+	if err := signer.check(); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+// check signs the DNSKEY RRset and a denial at the apex and verifies both
+// with the published keys, so a key that cannot sign fails at startup
+// rather than on the first query
+func (signer *Signer) check() error {
+	keySet := signer.Keys()
+	signed, err := signer.Sign(keySet)
+	if err != nil {
+		return err
+	}
+
+	rrset, sigs := splitRRset(signed, signer.zone, DNSKEY)
+	if err := verifyAny(sigs, rrset, []DnskeyRecord{signer.ksk.dnskey}); err != nil {
+		return fmt.Errorf("KSK for %q: %w", signer.zone, err)
+	}
+
+	denial, err := signer.DenyType(signer.zone, []QueryType{SOA, NS, DNSKEY}, dnskeyTTL)
+	if err != nil {
+		return err
+	}
+
+	nsec3Records, sigs := splitRRset(denial, denial[0].Header().domain, NSEC3)
+	if err := verifyAny(sigs, nsec3Records, []DnskeyRecord{signer.zsk.dnskey}); err != nil {
+		return fmt.Errorf("ZSK for %q: %w", signer.zone, err)
+	}
+
+	return nil
+}
+
+// Keys returns the zone's DNSKEY RRset
+func (signer *Signer) Keys() []Record {
+	return []Record{signer.ksk.dnskey, signer.zsk.dnskey}
+}
+
+// DsRecord returns the DS record the parent zone needs to publish for the KSK
+func (signer *Signer) DsRecord() DsRecord {
+	buffer := newCanonicalBuffer()
+	buffer.writeUncompressedQName(signer.zone)
+	signer.ksk.dnskey.writeRData(buffer)
+	digest := sha256.Sum256(buffer.Bytes())
+
+	return DsRecord{signer.zone, signer.ksk.dnskey.KeyTag(), signer.ksk.dnskey.algorithm, digestSHA256, digest[:], dnskeyTTL, IN}
+}
+
+// Sign returns records with an RRSIG after each RRset. The caller passes only
+// data the zone is authoritative for, so no delegation NS records or glue.
+func (signer *Signer) Sign(records []Record) ([]Record, error) {
+	signed := make([]Record, 0, 2*len(records))
+	for _, rrset := range groupRRsets(records) {
+		signed = append(signed, rrset...)
+
+		header := rrset[0].Header()
+		if header.qtype == RRSIG || header.qtype == OPT || !isSubdomain(header.domain, signer.zone) {
+			continue
+		}
+
+		sig, err := signer.signRRset(rrset)
+		if err != nil {
+			return nil, err
+		}
+		signed = append(signed, sig)
+	}
+
+	return signed, nil
+}
+
+// signRRset returns the RRSIG for rrset, reusing the one made earlier for the
+// same data until it comes close to expiring
+func (signer *Signer) signRRset(rrset []Record) (RrsigRecord, error) {
+	header := rrset[0].Header()
+	key := signer.zsk
+	if header.qtype == DNSKEY {
+		key = signer.ksk
+	}
+
+	ttl := header.ttl
+	for _, record := range rrset {
+		ttl = min(ttl, record.Header().ttl)
+	}
+
+	sig := RrsigRecord{domain: header.domain, typeCovered: header.qtype, algorithm: key.dnskey.algorithm, labels: uint8(labelCount(header.domain)),
+		originalTTL: ttl, keyTag: key.dnskey.KeyTag(), signerName: signer.zone, ttl: ttl, class: header.class}
+
+	// With the timestamps left at zero the signed data identifies the RRset
+	unsigned, err := signedData(sig, rrset)
+	if err != nil {
+		return RrsigRecord{}, err
+	}
+	digest := sha256.Sum256(unsigned)
+	cacheKey := string(digest[:])
+
+	now := time.Now()
+	signer.mutex.Lock()
+	cached, ok := signer.signatures[cacheKey]
+	signer.mutex.Unlock()
+	if ok && now.Add(signatureRefresh).Before(time.Unix(int64(cached.expiration), 0)) {
+		return cached, nil
+	}
+
+	sig.inception = uint32(now.Add(-signatureSkew).Unix())
+	sig.expiration = uint32(now.Add(signatureValidity).Unix())
+	data, err := signedData(sig, rrset)
+	if err != nil {
+		return RrsigRecord{}, err
+	}
+
+	if sig.signature, err = key.sign(data); err != nil {
+		return RrsigRecord{}, err
+	}
+
+	signer.mutex.Lock()
+	defer signer.mutex.Unlock()
+	if len(signer.signatures) >= maxSignatureEntries {
+		signer.purge(now)
+	}
+	if len(signer.signatures) < maxSignatureEntries {
+		signer.signatures[cacheKey] = sig
+	}
+
+	return sig, nil
+}
+
+// purge drops signatures due for replacement. The caller must hold the mutex.
+func (signer *Signer) purge(now time.Time) {
+	for key, sig := range signer.signatures {
+		if !now.Add(signatureRefresh).Before(time.Unix(int64(sig.expiration), 0)) {
+			delete(signer.signatures, key)
+		}
+	}
+}
+
+// DenyName proves qname does not exist, for an NXDOMAIN answer. The closest
+// encloser is qname's deepest existing ancestor and ceTypes are the types
+// present there. The NSEC3 records match the closest encloser and cover the
+// next closer name and the wildcard, as RFC 5155 section 7.2.2 requires.
+func (signer *Signer) DenyName(qname string, closestEncloser string, ceTypes []QueryType, ttl uint32) ([]Record, error) {
+	qname = normalizeName(qname)
+	closestEncloser = normalizeName(closestEncloser)
+	labels := strings.Split(qname, ".")
+	nextCloser := strings.Join(labels[len(labels)-labelCount(closestEncloser)-1:], ".")
+
+	records := []Record{
+		signer.matchingNsec3(closestEncloser, ceTypes, ttl),
+		signer.coveringNsec3(nextCloser, ttl),
+	}
+
+	// The wildcard's hash may fall inside the range already covering the next closer
+	wildcard := wildcardName(closestEncloser)
+	if !nsec3Covers(records[1].(Nsec3Record), wildcard) {
+		records = append(records, signer.coveringNsec3(wildcard, ttl))
+	}
+
+	return signer.Sign(records)
+}
+
+// DenyType proves name exists with only the given types, for a NODATA answer
+func (signer *Signer) DenyType(name string, types []QueryType, ttl uint32) ([]Record, error) {
+	return signer.Sign([]Record{signer.matchingNsec3(name, types, ttl)})
+}
+
+// matchingNsec3 makes up the NSEC3 record owned by the hash of name, listing
+// the types at name. The next hash is simply the owner's plus one.
+func (signer *Signer) matchingNsec3(name string, types []QueryType, ttl uint32) Nsec3Record {
+	hashed := nsec3Hash(name, nil, 0)
+	bitmap := make([]QueryType, len(types), len(types)+1)
+	copy(bitmap, types)
+	if len(types) > 0 {
+		bitmap = append(bitmap, RRSIG)
+	}
+
+	return signer.nsec3(hashed, addToHash(hashed, 1), bitmap, ttl)
+}
+
+// coveringNsec3 makes up an NSEC3 record whose range is just wide enough to
+// cover the hash of name: from one below it to one above it
+func (signer *Signer) coveringNsec3(name string, ttl uint32) Nsec3Record {
+	hashed := nsec3Hash(name, nil, 0)
+	return signer.nsec3(addToHash(hashed, -1), addToHash(hashed, 1), nil, ttl)
+}
+
+// nsec3 builds an NSEC3 record with the parameters RFC 9276 recommends: no
+// salt and no extra iterations
+func (signer *Signer) nsec3(owner []byte, next []byte, types []QueryType, ttl uint32) Nsec3Record {
+	domain := strings.ToLower(base32Hex.EncodeToString(owner))
+	if len(signer.zone) > 0 {
+		domain += "." + signer.zone
+	}
+
+	return Nsec3Record{domain, nsec3SHA1, 0, 0, nil, next, types, ttl, IN}
+}
+
+// addToHash adds delta to a hash read as one big-endian number, wrapping
+// round at either end
+func addToHash(hashed []byte, delta int) []byte {
+	result := make([]byte, len(hashed))
+	copy(result, hashed)
+
+	for idx := len(result) - 1; idx >= 0; idx-- {
+		if delta > 0 {
+			result[idx]++
+			if result[idx] != 0 {
+				break
+			}
+		} else {
+			result[idx]--
+			if result[idx] != 0xFF {
+				break
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// SvcParamKey identifies a parameter of an SVCB or HTTPS record (RFC 9460)
+type SvcParamKey uint16
+
+// SvcParamKey enumerations
+const (
+	MandatoryKey     SvcParamKey = 0
+	AlpnKey          SvcParamKey = 1
+	NoDefaultAlpnKey SvcParamKey = 2
+	PortKey          SvcParamKey = 3
+	Ipv4HintKey      SvcParamKey = 4
+	EchKey           SvcParamKey = 5
+	Ipv6HintKey      SvcParamKey = 6
+)
+
+func (key SvcParamKey) String() string {
+	switch key {
+	case MandatoryKey:
+		return "mandatory"
+	case AlpnKey:
+		return "alpn"
+	case NoDefaultAlpnKey:
+		return "no-default-alpn"
+	case PortKey:
+		return "port"
+	case Ipv4HintKey:
+		return "ipv4hint"
+	case EchKey:
+		return "ech"
+	case Ipv6HintKey:
+		return "ipv6hint"
+	default:
+		return fmt.Sprintf("key%d", uint16(key))
+	}
+}
+
+// SvcParam is a single key and value of an SVCB or HTTPS record
+type SvcParam interface {
+	Key() SvcParamKey
+	// pack returns the wire format of the value
+	pack() ([]byte, error)
+}
+
+// MandatoryParam lists the keys a client must understand to use the record
+type MandatoryParam struct {
+	keys []SvcParamKey
+}
+
+// AlpnParam lists the protocols supported by the service
+type AlpnParam struct {
+	protocols []string
+}
+
+// NoDefaultAlpnParam says the service does not support the default protocol
+type NoDefaultAlpnParam struct{}
+
+// PortParam is the port the service listens on
+type PortParam struct {
+	port uint16
+}
+
+// Ipv4HintParam lists IPv4 addresses a client may use before resolving the target
+type Ipv4HintParam struct {
+	addrs []net.IP
+}
+
+// EchParam holds an encoded ECHConfigList for Encrypted Client Hello
+type EchParam struct {
+	config []byte
+}
+
+// Ipv6HintParam lists IPv6 addresses a client may use before resolving the target
+type Ipv6HintParam struct {
+	addrs []net.IP
+}
+
+// UnknownParam keeps the raw value of a key this project does not model
+type UnknownParam struct {
+	key   SvcParamKey
+	value []byte
+}
+
+// Key returns the key of this parameter
+func (param MandatoryParam) Key() SvcParamKey { return MandatoryKey }
+
+// Key returns the key of this parameter
+func (param AlpnParam) Key() SvcParamKey { return AlpnKey }
+
+// Key returns the key of this parameter
+func (param NoDefaultAlpnParam) Key() SvcParamKey { return NoDefaultAlpnKey }
+
+// Key returns the key of this parameter
+func (param PortParam) Key() SvcParamKey { return PortKey }
+
+// Key returns the key of this parameter
+func (param Ipv4HintParam) Key() SvcParamKey { return Ipv4HintKey }
+
+// Key returns the key of this parameter
+func (param EchParam) Key() SvcParamKey { return EchKey }
+
+// Key returns the key of this parameter
+func (param Ipv6HintParam) Key() SvcParamKey { return Ipv6HintKey }
+
+// Key returns the key of this parameter
+func (param UnknownParam) Key() SvcParamKey { return param.key }
+
+func (param MandatoryParam) pack() ([]byte, error) {
+	if len(param.keys) == 0 {
+		return nil, InvalidInput("mandatory must list at least one key")
+	}
+
+	// The listed keys follow the same strictly increasing order as the parameters
+	keys := make([]SvcParamKey, len(param.keys))
+	copy(keys, param.keys)
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	value := make([]byte, 2*len(keys))
+	for idx, key := range keys {
+		if idx > 0 && key == keys[idx-1] {
+			return nil, InvalidInput(fmt.Sprintf("mandatory lists %s twice", key))
+		}
+		binary.BigEndian.PutUint16(value[2*idx:], uint16(key))
+	}
+
+	return value, nil
+}
+
+func (param AlpnParam) pack() ([]byte, error) {
+	if len(param.protocols) == 0 {
+		return nil, InvalidInput("alpn must list at least one protocol")
+	}
+
+	value := []byte{}
+	for _, protocol := range param.protocols {
+		if len(protocol) == 0 || len(protocol) > 255 {
+			return nil, InvalidInput(fmt.Sprintf("Invalid alpn protocol %q", protocol))
+		}
+		value = append(value, byte(len(protocol)))
+		value = append(value, protocol...)
+	}
+
+	return value, nil
+}
+
+func (param NoDefaultAlpnParam) pack() ([]byte, error) {
+	return []byte{}, nil
+}
+
+func (param PortParam) pack() ([]byte, error) {
+	return binary.BigEndian.AppendUint16(nil, param.port), nil
+}
+
+func (param Ipv4HintParam) pack() ([]byte, error) {
+	if len(param.addrs) == 0 {
+		return nil, InvalidInput("ipv4hint must list at least one address")
+	}
+
+	value := []byte{}
+	for _, addr := range param.addrs {
+		ipv4 := addr.To4()
+		if ipv4 == nil {
+			return nil, InvalidInput(fmt.Sprintf("Invalid ipv4hint address %s", addr))
+		}
+		value = append(value, ipv4...)
+	}
+
+	return value, nil
+}
+
+func (param EchParam) pack() ([]byte, error) {
+	return param.config, nil
+}
+
+func (param Ipv6HintParam) pack() ([]byte, error) {
+	if len(param.addrs) == 0 {
+		return nil, InvalidInput("ipv6hint must list at least one address")
+	}
+
+	value := []byte{}
+	for _, addr := range param.addrs {
+		// To16 would quietly turn an IPv4 address into a mapped one
+		ipv6 := addr.To16()
+		if ipv6 == nil || addr.To4() != nil {
+			return nil, InvalidInput(fmt.Sprintf("Invalid ipv6hint address %s", addr))
+		}
+		value = append(value, ipv6...)
+	}
+
+	return value, nil
+}
+
+func (param UnknownParam) pack() ([]byte, error) {
+	return param.value, nil
+}
+
+func (param MandatoryParam) String() string {
+	keys := make([]string, len(param.keys))
+	for idx, key := range param.keys {
+		keys[idx] = key.String()
+	}
+
+	return "mandatory=" + strings.Join(keys, ",")
+}
+
+func (param AlpnParam) String() string {
+	return "alpn=" + strings.Join(param.protocols, ",")
+}
+
+func (param NoDefaultAlpnParam) String() string {
+	return "no-default-alpn"
+}
+
+func (param PortParam) String() string {
+	return fmt.Sprintf("port=%d", param.port)
+}
+
+func (param Ipv4HintParam) String() string {
+	return "ipv4hint=" + joinAddrs(param.addrs)
+}
+
+func (param EchParam) String() string {
+	return "ech=" + base64.StdEncoding.EncodeToString(param.config)
+}
+
+func (param Ipv6HintParam) String() string {
+	return "ipv6hint=" + joinAddrs(param.addrs)
+}
+
+func (param UnknownParam) String() string {
+	return fmt.Sprintf("%s=%s", param.key, quoteString(string(param.value)))
+}
+
+func joinAddrs(addrs []net.IP) string {
+	parts := make([]string, len(addrs))
+	for idx, addr := range addrs {
+		parts[idx] = addr.String()
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// unpackSvcParam decodes the wire format value of a parameter
+func unpackSvcParam(key SvcParamKey, value []byte) (SvcParam, error) {
+	switch key {
+	case MandatoryKey:
+		if len(value) == 0 || len(value)%2 != 0 {
+			return nil, InvalidInput("Invalid mandatory parameter length")
+		}
+
+		keys := make([]SvcParamKey, len(value)/2)
+		for idx := range keys {
+			keys[idx] = SvcParamKey(binary.BigEndian.Uint16(value[2*idx:]))
+		}
+		return MandatoryParam{keys}, nil
+	case AlpnKey:
+		protocols := []string{}
+		for len(value) > 0 {
+			size := int(value[0])
+			if size == 0 || size+1 > len(value) {
+				return nil, InvalidInput("Invalid alpn parameter")
+			}
+			protocols = append(protocols, string(value[1:size+1]))
+			value = value[size+1:]
+		}
+
+		if len(protocols) == 0 {
+			return nil, InvalidInput("Empty alpn parameter")
+		}
+		return AlpnParam{protocols}, nil
+	case NoDefaultAlpnKey:
+		if len(value) != 0 {
+			return nil, InvalidInput("no-default-alpn must not have a value")
+		}
+		return NoDefaultAlpnParam{}, nil
+	case PortKey:
+		if len(value) != 2 {
+			return nil, InvalidInput("Invalid port parameter length")
+		}
+		return PortParam{binary.BigEndian.Uint16(value)}, nil
+	case Ipv4HintKey:
+		if len(value) == 0 || len(value)%net.IPv4len != 0 {
+			return nil, InvalidInput("Invalid ipv4hint parameter length")
+		}
+
+		addrs := []net.IP{}
+		for idx := 0; idx < len(value); idx += net.IPv4len {
+			addrs = append(addrs, net.IPv4(value[idx], value[idx+1], value[idx+2], value[idx+3]))
+		}
+		return Ipv4HintParam{addrs}, nil
+	case EchKey:
+		return EchParam{value}, nil
+	case Ipv6HintKey:
+		if len(value) == 0 || len(value)%net.IPv6len != 0 {
+			return nil, InvalidInput("Invalid ipv6hint parameter length")
+		}
+
+		addrs := []net.IP{}
+		for idx := 0; idx < len(value); idx += net.IPv6len {
+			addr := make(net.IP, net.IPv6len)
+			copy(addr, value[idx:idx+net.IPv6len])
+			addrs = append(addrs, addr)
+		}
+		return Ipv6HintParam{addrs}, nil
+	default:
+		return UnknownParam{key, value}, nil
+	}
+}
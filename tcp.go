@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// readTCPMessage reads a single message prefixed with its two byte length (RFC 7766 section 8)
+func readTCPMessage(conn net.Conn) ([]byte, error) {
+	prefix := make([]byte, 2)
+	if _, err := io.ReadFull(conn, prefix); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint16(prefix))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// writeTCPMessage writes a single message prefixed with its two byte length.
+// The prefix and the message go out in one write so they share a segment.
+func writeTCPMessage(conn net.Conn, data []byte) error {
+	if len(data) > MaxMessageSize {
+		return InvalidInput("Message exceeds 65535 bytes")
+	}
+
+	framed := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(framed, uint16(len(data)))
+	copy(framed[2:], data)
+
+	_, err := conn.Write(framed)
+	return err
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// holdDownTime is how long a new key must be seen before it becomes a
+	// trust anchor, and how long a revoked key is remembered (RFC 5011 section 2.4.1)
+	holdDownTime = 30 * 24 * time.Hour
+	// anchorRefreshInterval is how often the DNSKEY RRsets of trust points are
+	// fetched again, the shortest interval RFC 5011 section 2.3 allows
+	anchorRefreshInterval = time.Hour
+)
+
+// anchorState is where a key stands in the RFC 5011 state machine
+type anchorState string
+
+const (
+	// addPending keys have been seen but are still in their add hold-down
+	addPending anchorState = "AddPend"
+	// validKey keys are trust anchors
+	validKey anchorState = "Valid"
+	// missingKey keys are trust anchors the zone no longer publishes
+	missingKey anchorState = "Missing"
+	// revokedKey keys revoked themselves and are no longer trusted
+	revokedKey anchorState = "Revoked"
+)
+
+// managedKey is one key the store tracks, in the form it is saved to disk
+type managedKey struct {
+	Zone      string      `json:"zone"`
+	Flags     uint16      `json:"flags"`
+	Protocol  uint8       `json:"protocol"`
+	Algorithm uint8       `json:"algorithm"`
+	PublicKey []byte      `json:"publicKey"`
+	State     anchorState `json:"state"`
+	FirstSeen time.Time   `json:"firstSeen"`
+	// HoldDown is when an AddPend key may become Valid, or when a Revoked
+	// key may be forgotten
+	HoldDown time.Time `json:"holdDown"`
+}
+
+func newManagedKey(zone string, key DnskeyRecord, state anchorState) managedKey {
+	return managedKey{zone, key.flags, key.protocol, key.algorithm, key.publicKey, state, time.Now(), time.Time{}}
+}
+
+func (key managedKey) dnskey() DnskeyRecord {
+	return DnskeyRecord{key.Zone, key.Flags, key.Protocol, key.Algorithm, key.PublicKey, 0, IN}
+}
+
+func (key managedKey) trusted() bool {
+	return key.State == validKey || key.State == missingKey
+}
+
+// sameKey reports whether two DNSKEYs are the same key, whether or not
+// either has been revoked
+func sameKey(a DnskeyRecord, b DnskeyRecord) bool {
+	const revoke = 0x0080
+	return a.flags&^revoke == b.flags&^revoke && a.protocol == b.protocol && a.algorithm == b.algorithm && bytes.Equal(a.publicKey, b.publicKey)
+}
+
+// TrustAnchorStore keeps the trust anchors of each trust point up to date
+// as its key signing keys roll over, following RFC 5011, and saves them to a
+// state file so they survive restarts.
+type TrustAnchorStore struct {
+	mutex sync.Mutex
+	path  string
+	keys  []managedKey
+}
+
+// anchorStore tracks the trust anchors validation starts from. It only lives
+// in memory until main points it at a state file.
+var anchorStore = &TrustAnchorStore{}
+
+// NewTrustAnchorStore creates a store saved at path, loading the keys
+// already saved there. A missing file is an empty store.
+func NewTrustAnchorStore(path string) (*TrustAnchorStore, error) {
+	store := &TrustAnchorStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &store.keys); err != nil {
+		return nil, err
+	}
+
+	for idx := range store.keys {
+		store.keys[idx].Zone = normalizeName(store.keys[idx].Zone)
+	}
+
+	return store, nil
+}
+
+// Manages reports whether the store tracks keys for zone
+func (store *TrustAnchorStore) Manages(zone string) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, key := range store.keys {
+		if key.Zone == normalizeName(zone) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Zones lists the trust points the store tracks
+func (store *TrustAnchorStore) Zones() []string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	zones := []string{}
+	seen := make(map[string]bool)
+	for _, key := range store.keys {
+		if !seen[key.Zone] {
+			seen[key.Zone] = true
+			zones = append(zones, key.Zone)
+		}
+	}
+
+	return zones
+}
+
+// Trusted returns the keys of zone that are currently trust anchors
+func (store *TrustAnchorStore) Trusted(zone string) []DnskeyRecord {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	keys := []DnskeyRecord{}
+	for _, key := range store.keys {
+		if key.Zone == normalizeName(zone) && key.trusted() {
+			keys = append(keys, key.dnskey())
+		}
+	}
+
+	return keys
+}
+
+// Observe runs the RFC 5011 state machine over a DNSKEY RRset of zone that
+// has just validated against the current trust anchors. While the store has
+// no keys for zone, the keys matching the configured DS records become its
+// first trust anchors.
+func (store *TrustAnchorStore) Observe(zone string, keySet []Record, sigs []RrsigRecord, bootstrap []DsRecord) error {
+	zone = normalizeName(zone)
+	now := time.Now()
+
+	// A new key has to stay published for the hold-down time, or for the
+	// RRset's TTL if that is longer
+	holdDown := holdDownTime
+	for _, sig := range sigs {
+		holdDown = max(holdDown, time.Duration(sig.originalTTL)*time.Second)
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	bootstrapping := true
+	for _, key := range store.keys {
+		if key.Zone == zone {
+			bootstrapping = false
+			break
+		}
+	}
+
+	changed := false
+	seen := make(map[int]bool)
+	dropped := make(map[int]bool)
+	for _, record := range keySet {
+		dnskeyRecord := record.(DnskeyRecord)
+		if !dnskeyRecord.IsSecureEntryPoint() {
+			continue
+		}
+
+		idx := store.find(zone, dnskeyRecord)
+		if dnskeyRecord.IsRevoked() {
+			// Only the key itself can revoke a key, by signing the RRset
+			if idx < 0 || verifyRevocation(sigs, keySet, dnskeyRecord) != nil {
+				continue
+			}
+
+			seen[idx] = true
+			switch store.keys[idx].State {
+			case validKey, missingKey:
+				fmt.Printf("Trust anchor %d for %q has been revoked\n", dnskeyRecord.KeyTag(), zone)
+				store.keys[idx].State = revokedKey
+				store.keys[idx].HoldDown = now.Add(holdDownTime)
+				changed = true
+			case addPending:
+				dropped[idx] = true
+				changed = true
+			}
+			continue
+		}
+
+		if idx < 0 {
+			key := newManagedKey(zone, dnskeyRecord, validKey)
+			if !bootstrapping || !matchesAnyDs(bootstrap, dnskeyRecord) {
+				key.State = addPending
+				key.HoldDown = now.Add(holdDown)
+				fmt.Printf("New key %d for %q, trusted after %s\n", dnskeyRecord.KeyTag(), zone, key.HoldDown.Format(time.RFC3339))
+			}
+
+			store.keys = append(store.keys, key)
+			seen[len(store.keys)-1] = true
+			changed = true
+			continue
+		}
+
+		seen[idx] = true
+		switch store.keys[idx].State {
+		case addPending:
+			if now.After(store.keys[idx].HoldDown) {
+				fmt.Printf("Key %d for %q is now a trust anchor\n", dnskeyRecord.KeyTag(), zone)
+				store.keys[idx].State = validKey
+				changed = true
+			}
+		case missingKey:
+			store.keys[idx].State = validKey
+			changed = true
+		}
+	}
+
+	kept := make([]managedKey, 0, len(store.keys))
+	for idx, key := range store.keys {
+		if dropped[idx] {
+			continue
+		}
+
+		if key.Zone == zone {
+			switch {
+			case key.State == revokedKey && now.After(key.HoldDown):
+				// The remove hold-down is over, so the key can be forgotten
+				changed = true
+				continue
+			case key.State == addPending && !seen[idx]:
+				// A key that disappears during its add hold-down starts over
+				changed = true
+				continue
+			case key.State == validKey && !seen[idx]:
+				key.State = missingKey
+				changed = true
+			}
+		}
+		kept = append(kept, key)
+	}
+	store.keys = kept
+
+	if !changed {
+		return nil
+	}
+
+	return store.save()
+}
+
+// find returns the index of the stored key matching key, or -1. The caller
+// must hold the mutex.
+func (store *TrustAnchorStore) find(zone string, key DnskeyRecord) int {
+	for idx, managed := range store.keys {
+		if managed.Zone == zone && sameKey(managed.dnskey(), key) {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// save writes the keys to the state file, replacing it in one step so a
+// crash cannot leave it half written. The caller must hold the mutex.
+func (store *TrustAnchorStore) save() error {
+	if len(store.path) == 0 {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(store.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := store.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, store.path)
+}
+
+// maintainTrustAnchors fetches the DNSKEY RRset of every trust point from
+// its servers at regular intervals, bypassing the cache, so the store sees
+// key rollovers even when no queries for those zones arrive.
+func maintainTrustAnchors() {
+	for {
+		zones := anchorStore.Zones()
+		for _, anchor := range trustAnchors {
+			if !hasZone(zones, anchor.domain) {
+				zones = append(zones, normalizeName(anchor.domain))
+			}
+		}
+
+		for _, zone := range zones {
+			if _, status := refreshZoneKeys(zone); status != Secure {
+				fmt.Printf("Trust point %q is %s\n", zone, status)
+			}
+		}
+
+		time.Sleep(anchorRefreshInterval)
+	}
+}
+
+func matchesAnyDs(dsRecords []DsRecord, key DnskeyRecord) bool {
+	for _, dsRecord := range dsRecords {
+		if dsMatches(dsRecord, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasZone(zones []string, zone string) bool {
+	for _, other := range zones {
+		if other == normalizeName(zone) {
+			return true
+		}
+	}
+
+	return false
+}
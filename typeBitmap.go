@@ -0,0 +1,67 @@
+package main
+
+import "sort"
+
+// packTypeBitmap encodes a set of types as the window blocks used by NSEC
+// and NSEC3 records (RFC 4034 section 4.1.2)
+func packTypeBitmap(types []QueryType) []byte {
+	sorted := make([]QueryType, len(types))
+	copy(sorted, types)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	data := []byte{}
+	for idx := 0; idx < len(sorted); {
+		window := byte(sorted[idx] >> 8)
+		bitmap := make([]byte, 32)
+		length := 0
+		for ; idx < len(sorted) && byte(sorted[idx]>>8) == window; idx++ {
+			low := byte(sorted[idx])
+			bitmap[low/8] |= 0x80 >> (low % 8)
+			length = int(low/8) + 1
+		}
+
+		data = append(data, window, byte(length))
+		data = append(data, bitmap[:length]...)
+	}
+
+	return data
+}
+
+// unpackTypeBitmap decodes NSEC and NSEC3 window blocks into a list of types
+func unpackTypeBitmap(data []byte) ([]QueryType, error) {
+	types := []QueryType{}
+	lastWindow := -1
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, InvalidInput("Truncated type bitmap window")
+		}
+
+		window, length := int(data[0]), int(data[1])
+		if window <= lastWindow || length == 0 || length > 32 || len(data) < 2+length {
+			return nil, InvalidInput("Invalid type bitmap window")
+		}
+		lastWindow = window
+
+		for byteIdx, bits := range data[2 : 2+length] {
+			for bit := 0; bit < 8; bit++ {
+				if bits&(0x80>>bit) != 0 {
+					types = append(types, QueryType(window<<8|byteIdx*8+bit))
+				}
+			}
+		}
+		data = data[2+length:]
+	}
+
+	return types, nil
+}
+
+// hasType reports whether a type bitmap includes qtype
+func hasType(types []QueryType, qtype QueryType) bool {
+	for _, t := range types {
+		if t == qtype {
+			return true
+		}
+	}
+
+	return false
+}
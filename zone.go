@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Zone is a zone the server answers for with authority, loaded from a
+// master file
+type Zone struct {
+	origin string
+	soa    SoaRecord
+	// records holds every record of the zone by owner name
+	records map[string][]Record
+	// names holds every name that exists, empty non-terminals included
+	names map[string]bool
+	// signer signs answers when the zone is served with DNSSEC
+	signer *Signer
+}
+
+// zones are the zones the server is authoritative for. They are loaded at
+// startup and only read afterwards.
+var zones = []*Zone{}
+
+// LoadZone reads the master file at path as the zone origin
+func LoadZone(origin string, path string) (*Zone, error) {
+	records, err := LoadZoneFile(path, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewZone(origin, records)
+}
+
+// NewZone builds a zone from its records, which must include one SOA and the
+// NS records at the apex. Records outside the zone are left out.
+func NewZone(origin string, records []Record) (*Zone, error) {
+	zone := &Zone{origin: normalizeName(origin), records: make(map[string][]Record), names: make(map[string]bool)}
+	for _, record := range records {
+		owner := normalizeName(record.Header().domain)
+		if !isSubdomain(owner, zone.origin) {
+			fmt.Printf("Ignoring %s, which is outside zone %q\n", record, zone.origin)
+			continue
+		}
+
+		if err := zone.add(record); err != nil {
+			return nil, err
+		}
+	}
+
+	soaRecords := zone.rrset(zone.origin, SOA)
+	if len(soaRecords) != 1 {
+		return nil, InvalidInput(fmt.Sprintf("Zone %q needs exactly one SOA record at its apex", zone.origin))
+	}
+	zone.soa = soaRecords[0].(SoaRecord)
+
+	if len(zone.rrset(zone.origin, NS)) == 0 {
+		return nil, InvalidInput(fmt.Sprintf("Zone %q has no NS records at its apex", zone.origin))
+	}
+
+	// A CNAME cannot share its owner with other data (RFC 2181 section 10.1)
+	for owner, ownerRecords := range zone.records {
+		if len(zone.rrset(owner, CNAME)) == 0 {
+			continue
+		}
+
+		for _, record := range ownerRecords {
+			switch record.Header().qtype {
+			case CNAME, RRSIG, NSEC, NSEC3:
+			default:
+				return nil, InvalidInput(fmt.Sprintf("CNAME at %q has other data beside it", owner))
+			}
+		}
+	}
+
+	return zone, nil
+}
+
+// add stores a record and marks its owner and every name above it, up to
+// the apex, as existing. Duplicate records are only kept once.
+func (zone *Zone) add(record Record) error {
+	rdata, err := canonicalRData(record)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", record.Header().domain, record.Header().qtype, err)
+	}
+
+	owner := normalizeName(record.Header().domain)
+	for _, existing := range zone.rrset(owner, record.Header().qtype) {
+		if existingData, err := canonicalRData(existing); err == nil && bytes.Equal(existingData, rdata) {
+			return nil
+		}
+	}
+	zone.records[owner] = append(zone.records[owner], record)
+
+	for name := owner; ; name = parentName(name) {
+		zone.names[name] = true
+		if name == zone.origin || len(name) == 0 {
+			break
+		}
+	}
+
+	return nil
+}
+
+// UseSigner signs the zone's answers with signer from now on and publishes
+// its keys at the apex
+func (zone *Zone) UseSigner(signer *Signer) error {
+	if signer.zone != zone.origin {
+		return InvalidInput(fmt.Sprintf("Keys for %q cannot sign zone %q", signer.zone, zone.origin))
+	}
+
+	zone.signer = signer
+	for _, record := range signer.Keys() {
+		if err := zone.add(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rrset returns the records of qtype owned by name
+func (zone *Zone) rrset(name string, qtype QueryType) []Record {
+	rrset := []Record{}
+	for _, record := range zone.records[name] {
+		if record.Header().qtype == qtype {
+			rrset = append(rrset, record)
+		}
+	}
+
+	return rrset
+}
+
+// types lists the types present at name
+func (zone *Zone) types(name string) []QueryType {
+	types := []QueryType{}
+	for _, record := range zone.records[name] {
+		qtype := record.Header().qtype
+		if !containsType(types, qtype) {
+			types = append(types, qtype)
+		}
+	}
+
+	return types
+}
+
+func containsType(types []QueryType, qtype QueryType) bool {
+	for _, other := range types {
+		if other == qtype {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ancestors lists the names from the apex down to name
+func (zone *Zone) ancestors(name string) []string {
+	names := []string{name}
+	for name != zone.origin && len(name) > 0 {
+		name = parentName(name)
+		names = append([]string{name}, names...)
+	}
+
+	return names
+}
+
+// closestEncloser returns the deepest existing name at or above name
+func (zone *Zone) closestEncloser(name string) string {
+	for !zone.names[name] && name != zone.origin {
+		name = parentName(name)
+	}
+
+	return name
+}
+
+// findAuthZone returns the most specific local zone holding the answer to
+// qname, or nil. DS records at a zone's apex belong to its parent.
+func findAuthZone(qname string, qtype QueryType) *Zone {
+	name := normalizeName(qname)
+	var best *Zone
+	for _, zone := range zones {
+		if !isSubdomain(name, zone.origin) || (qtype == DS && name == zone.origin) {
+			continue
+		}
+
+		if best == nil || labelCount(zone.origin) > labelCount(best.origin) {
+			best = zone
+		}
+	}
+
+	return best
+}
+
+// servedLocally reports whether the records of qtype at name come straight
+// from one of our zones rather than from the servers it delegates to
+func servedLocally(name string, qtype QueryType) bool {
+	zone := findAuthZone(name, qtype)
+	if zone == nil {
+		return false
+	}
+
+	name = normalizeName(name)
+	for _, ancestor := range zone.ancestors(name) {
+		if ancestor != zone.origin && len(zone.rrset(ancestor, NS)) > 0 && !(ancestor == name && qtype == DS) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Answer looks qname up in the zone's data. It follows CNAME and DNAME
+// records as far as they stay inside the zone, refers the client to the
+// child's servers at a delegation, and otherwise answers NXDOMAIN or NODATA
+// with the SOA in the authority section (RFC 1034 section 4.3.2).
+func (zone *Zone) Answer(qname string, qtype QueryType, dnssecOk bool) (Packet, error) {
+	response := Packet{header: Header{response: true, authoritativeAnswer: true}}
+	response.questions = []Question{{name: qname, qType: qtype, class: IN}}
+	name := normalizeName(qname)
+
+	for hops := 0; ; hops++ {
+		if hops > maxChainLength {
+			fmt.Printf("CNAME chain for %s loops or is too long\n", qname)
+			response.header.rescode = SERVFAIL
+			return response, nil
+		}
+
+		// Data below a zone cut or a DNAME is not ours to answer with
+		redirected := false
+		for _, ancestor := range zone.ancestors(name) {
+			if ancestor != zone.origin && len(zone.rrset(ancestor, NS)) > 0 && !(ancestor == name && qtype == DS) {
+				if len(response.answers) == 0 {
+					return zone.referral(response, ancestor, dnssecOk)
+				}
+				return response, nil
+			}
+
+			dnameRecords := zone.rrset(ancestor, DNAME)
+			if ancestor == name || len(dnameRecords) == 0 {
+				continue
+			}
+
+			dnameRecord := dnameRecords[0].(DnameRecord)
+			if err := zone.appendSigned(&response.answers, dnameRecords, dnssecOk); err != nil {
+				return response, err
+			}
+
+			substituted, ok := dnameRecord.Substitute(name)
+			if !ok {
+				response.header.rescode = YXDOMAIN
+				return response, nil
+			}
+
+			// The synthesized CNAME is never signed, validators rebuild it from the DNAME
+			response.answers = append(response.answers, CNameRecord{name, substituted, dnameRecord.ttl, IN})
+			name = normalizeName(substituted)
+			redirected = true
+			break
+		}
+
+		if redirected {
+			if !isSubdomain(name, zone.origin) {
+				return response, nil
+			}
+			continue
+		}
+
+		// Names that do not exist may still be covered by a wildcard
+		source := name
+		if !zone.names[name] {
+			closestEncloser := zone.closestEncloser(name)
+			source = wildcardName(closestEncloser)
+			if !zone.names[source] {
+				response.header.rescode = NXDOMAIN
+				return zone.denyName(response, name, closestEncloser, dnssecOk)
+			}
+		}
+
+		if rrset := zone.expand(source, name, qtype); len(rrset) > 0 {
+			err := zone.appendSigned(&response.answers, rrset, dnssecOk)
+			return response, err
+		}
+
+		cnameRecords := zone.expand(source, name, CNAME)
+		if len(cnameRecords) == 0 {
+			return zone.denyType(response, name, zone.types(source), dnssecOk)
+		}
+
+		if err := zone.appendSigned(&response.answers, cnameRecords, dnssecOk); err != nil {
+			return response, err
+		}
+
+		name = normalizeName(cnameRecords[0].(CNameRecord).host)
+		if !isSubdomain(name, zone.origin) {
+			return response, nil
+		}
+	}
+}
+
+// expand returns the records of qtype at source, owned by name instead when
+// source is the wildcard that matched name
+func (zone *Zone) expand(source string, name string, qtype QueryType) []Record {
+	rrset := zone.rrset(source, qtype)
+	if source == name {
+		return rrset
+	}
+
+	expanded := make([]Record, len(rrset))
+	for idx, record := range rrset {
+		expanded[idx] = record.WithDomain(name)
+	}
+
+	return expanded
+}
+
+// referral points the client at the servers for the child zone cut, with
+// glue for the servers that live inside this zone. The referral is not
+// authoritative, apart from the DS records or the proof there are none.
+func (zone *Zone) referral(response Packet, cut string, dnssecOk bool) (Packet, error) {
+	response.header.authoritativeAnswer = false
+	nsRecords := zone.rrset(cut, NS)
+	response.authorities = append(response.authorities, nsRecords...)
+
+	if zone.signer != nil && dnssecOk {
+		if dsRecords := zone.rrset(cut, DS); len(dsRecords) > 0 {
+			if err := zone.appendSigned(&response.authorities, dsRecords, dnssecOk); err != nil {
+				return response, err
+			}
+		} else {
+			denial, err := zone.signer.DenyType(cut, zone.types(cut), zone.negativeTTL())
+			if err != nil {
+				return response, err
+			}
+			response.authorities = append(response.authorities, denial...)
+		}
+	}
+
+	for _, record := range nsRecords {
+		host := normalizeName(record.(NsRecord).host)
+		if !isSubdomain(host, zone.origin) {
+			continue
+		}
+
+		response.resources = append(response.resources, zone.rrset(host, A)...)
+		response.resources = append(response.resources, zone.rrset(host, AAAA)...)
+	}
+
+	return response, nil
+}
+
+// denyName answers that name does not exist
+func (zone *Zone) denyName(response Packet, name string, closestEncloser string, dnssecOk bool) (Packet, error) {
+	if err := zone.appendSigned(&response.authorities, []Record{zone.soa.WithTTL(zone.negativeTTL())}, dnssecOk); err != nil {
+		return response, err
+	}
+
+	if zone.signer != nil && dnssecOk {
+		denial, err := zone.signer.DenyName(name, closestEncloser, zone.types(closestEncloser), zone.negativeTTL())
+		if err != nil {
+			return response, err
+		}
+		response.authorities = append(response.authorities, denial...)
+	}
+
+	return response, nil
+}
+
+// denyType answers that name exists but has none of the records asked for
+func (zone *Zone) denyType(response Packet, name string, types []QueryType, dnssecOk bool) (Packet, error) {
+	if err := zone.appendSigned(&response.authorities, []Record{zone.soa.WithTTL(zone.negativeTTL())}, dnssecOk); err != nil {
+		return response, err
+	}
+
+	if zone.signer != nil && dnssecOk {
+		denial, err := zone.signer.DenyType(name, types, zone.negativeTTL())
+		if err != nil {
+			return response, err
+		}
+		response.authorities = append(response.authorities, denial...)
+	}
+
+	return response, nil
+}
+
+// negativeTTL is how long resolvers may cache a denial from this zone (RFC 2308 section 5)
+func (zone *Zone) negativeTTL() uint32 {
+	return min(zone.soa.ttl, zone.soa.minimum)
+}
+
+// appendSigned adds records to a section, each RRset followed by its RRSIG
+// when the zone is signed and the client asked for DNSSEC records
+func (zone *Zone) appendSigned(section *[]Record, records []Record, dnssecOk bool) error {
+	if zone.signer == nil || !dnssecOk {
+		*section = append(*section, records...)
+		return nil
+	}
+
+	signed, err := zone.signer.Sign(records)
+	if err != nil {
+		return err
+	}
+
+	*section = append(*section, signed...)
+	return nil
+}
+
+func (zone *Zone) String() string {
+	count := 0
+	for _, records := range zone.records {
+		count += len(records)
+	}
+
+	signed := "unsigned"
+	if zone.signer != nil {
+		signed = "signed"
+	}
+
+	return fmt.Sprintf("zone %q (serial %d, %d records, %s)", zone.origin, zone.soa.serial, count, signed)
+}
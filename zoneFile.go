@@ -0,0 +1,1007 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxIncludeDepth bounds how deeply $INCLUDE directives may nest, which also
+// stops a file from including itself forever
+const maxIncludeDepth = 8
+
+// zoneToken is one field of a zone file entry
+type zoneToken struct {
+	text string
+	// quoted tokens were written in double quotes, so may be empty or hold spaces
+	quoted bool
+}
+
+// zoneEntry is one logical line of a zone file, with parentheses already
+// joined across physical lines
+type zoneEntry struct {
+	tokens []zoneToken
+	// indented entries start with whitespace and so reuse the previous owner
+	indented bool
+	line     int
+}
+
+// splitZoneEntries breaks a zone file into entries and tokens, dropping
+// comments and joining lines inside parentheses (RFC 1035 section 5.1).
+// Escapes are kept as written, for each field to decode as it needs.
+func splitZoneEntries(data string) ([]zoneEntry, error) {
+	entries := []zoneEntry{}
+	entry := zoneEntry{line: 1}
+	var token strings.Builder
+	inToken, quoted, inQuote := false, false, false
+	depth, line := 0, 1
+
+	endToken := func() {
+		if inToken {
+			entry.tokens = append(entry.tokens, zoneToken{token.String(), quoted})
+		}
+		token.Reset()
+		inToken, quoted = false, false
+	}
+
+	for idx := 0; idx < len(data); idx++ {
+		char := data[idx]
+		switch {
+		case char == '\\':
+			// Keep the escape and the character it protects together
+			token.WriteByte(char)
+			if idx+1 < len(data) {
+				idx++
+				token.WriteByte(data[idx])
+			}
+			inToken = true
+		case char == '"':
+			if !inToken {
+				quoted = true
+			}
+			inToken = true
+			inQuote = !inQuote
+		case inQuote:
+			if char == '\n' {
+				return nil, InvalidInput(fmt.Sprintf("Unterminated string on line %d", line))
+			}
+			token.WriteByte(char)
+		case char == ';':
+			for idx+1 < len(data) && data[idx+1] != '\n' {
+				idx++
+			}
+		case char == '(':
+			endToken()
+			depth++
+		case char == ')':
+			endToken()
+			if depth--; depth < 0 {
+				return nil, InvalidInput(fmt.Sprintf("Unbalanced parentheses on line %d", line))
+			}
+		case char == '\n':
+			endToken()
+			line++
+			if depth > 0 {
+				continue
+			}
+
+			if len(entry.tokens) > 0 {
+				entries = append(entries, entry)
+			}
+			entry = zoneEntry{line: line}
+			if idx+1 < len(data) && (data[idx+1] == ' ' || data[idx+1] == '\t') {
+				entry.indented = true
+			}
+		case char == ' ' || char == '\t' || char == '\r':
+			endToken()
+		default:
+			token.WriteByte(char)
+			inToken = true
+		}
+	}
+
+	if inQuote {
+		return nil, InvalidInput(fmt.Sprintf("Unterminated string on line %d", line))
+	}
+	if depth > 0 {
+		return nil, InvalidInput(fmt.Sprintf("Unbalanced parentheses on line %d", line))
+	}
+
+	endToken()
+	if len(entry.tokens) > 0 {
+		entries = append(entries, entry)
+	}
+
+	if len(data) > 0 && (data[0] == ' ' || data[0] == '\t') && len(entries) > 0 && entries[0].line == 1 {
+		entries[0].indented = true
+	}
+
+	return entries, nil
+}
+
+// decodeText resolves the \X and \DDD escapes of a zone file field
+func decodeText(text string) (string, error) {
+	if !strings.Contains(text, "\\") {
+		return text, nil
+	}
+
+	var builder strings.Builder
+	for idx := 0; idx < len(text); idx++ {
+		if text[idx] != '\\' {
+			builder.WriteByte(text[idx])
+			continue
+		}
+
+		if idx+3 < len(text) && isDigits(text[idx+1:idx+4]) {
+			value, err := strconv.ParseUint(text[idx+1:idx+4], 10, 8)
+			if err != nil {
+				return "", InvalidInput(fmt.Sprintf("Invalid escape in %q", text))
+			}
+			builder.WriteByte(byte(value))
+			idx += 3
+			continue
+		}
+
+		if idx+1 >= len(text) {
+			return "", InvalidInput(fmt.Sprintf("Dangling escape in %q", text))
+		}
+		idx++
+		builder.WriteByte(text[idx])
+	}
+
+	return builder.String(), nil
+}
+
+// splitValueList splits a comma separated SvcParam value into its items,
+// decoding escapes as it goes so that an escaped comma stays part of its item
+// (RFC 9460 appendix A.1)
+func splitValueList(text string) ([]string, error) {
+	items := []string{}
+	var builder strings.Builder
+	for idx := 0; idx < len(text); idx++ {
+		switch {
+		case text[idx] == ',':
+			items = append(items, builder.String())
+			builder.Reset()
+		case text[idx] != '\\':
+			builder.WriteByte(text[idx])
+		case idx+3 < len(text) && isDigits(text[idx+1:idx+4]):
+			value, err := strconv.ParseUint(text[idx+1:idx+4], 10, 8)
+			if err != nil {
+				return nil, InvalidInput(fmt.Sprintf("Invalid escape in %q", text))
+			}
+			builder.WriteByte(byte(value))
+			idx += 3
+		case idx+1 < len(text):
+			idx++
+			builder.WriteByte(text[idx])
+		default:
+			return nil, InvalidInput(fmt.Sprintf("Dangling escape in %q", text))
+		}
+	}
+
+	return append(items, builder.String()), nil
+}
+
+func isDigits(text string) bool {
+	for _, char := range []byte(text) {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+
+	return len(text) > 0
+}
+
+// parseTTL reads a TTL in seconds, also accepting the 1h30m style units
+// most name servers allow
+func parseTTL(text string) (uint32, bool) {
+	if isDigits(text) {
+		value, err := strconv.ParseUint(text, 10, 32)
+		return uint32(value), err == nil
+	}
+
+	units := map[byte]uint64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}
+	total, value, digits := uint64(0), uint64(0), false
+	for _, char := range []byte(strings.ToLower(text)) {
+		if char >= '0' && char <= '9' {
+			value = 10*value + uint64(char-'0')
+			digits = true
+			continue
+		}
+
+		unit, ok := units[char]
+		if !ok || !digits {
+			return 0, false
+		}
+		total += value * unit
+		value, digits = 0, false
+	}
+
+	if digits || total > 0xFFFFFFFF {
+		return 0, false
+	}
+
+	return uint32(total), true
+}
+
+// zoneParser turns master files into records, keeping the state that
+// carries over from one entry to the next
+type zoneParser struct {
+	origin string
+	owner  string
+
+	// defaultTTL is set by $TTL, lastTTL by the previous entry
+	defaultTTL    uint32
+	hasDefaultTTL bool
+	lastTTL       uint32
+	hasLastTTL    bool
+
+	records []Record
+}
+
+// LoadZoneFile reads the records of an RFC 1035 master file, resolving
+// relative names against origin until a $ORIGIN directive changes it
+func LoadZoneFile(path string, origin string) ([]Record, error) {
+	parser := &zoneParser{origin: normalizeName(origin), owner: normalizeName(origin)}
+	if err := parser.parseFile(path, 0); err != nil {
+		return nil, err
+	}
+
+	return parser.records, nil
+}
+
+func (parser *zoneParser) parseFile(path string, depth int) error {
+	if depth > maxIncludeDepth {
+		return InvalidInput(fmt.Sprintf("$INCLUDE nested too deeply at %s", path))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	entries, err := splitZoneEntries(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, entry := range entries {
+		if err := parser.parseEntry(path, entry, depth); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, entry.line, err)
+		}
+	}
+
+	return nil
+}
+
+func (parser *zoneParser) parseEntry(path string, entry zoneEntry, depth int) error {
+	tokens := entry.tokens
+	if !entry.indented && !tokens[0].quoted && strings.HasPrefix(tokens[0].text, "$") {
+		return parser.parseDirective(path, tokens, depth)
+	}
+
+	owner := parser.owner
+	if !entry.indented {
+		name, err := parser.name(tokens[0].text)
+		if err != nil {
+			return err
+		}
+		owner = name
+		tokens = tokens[1:]
+	}
+	parser.owner = owner
+
+	// The TTL and class may come in either order, and either may be left out
+	ttl, hasTTL := uint32(0), false
+	class, hasClass := IN, false
+	for len(tokens) > 0 && !(hasTTL && hasClass) {
+		if value, ok := parseTTL(tokens[0].text); ok && !hasTTL {
+			ttl, hasTTL = value, true
+		} else if value, ok := parseClass(tokens[0].text); ok && !hasClass {
+			class, hasClass = value, true
+		} else {
+			break
+		}
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) == 0 {
+		return InvalidInput("Missing record type")
+	}
+
+	if class != IN {
+		return InvalidInput(fmt.Sprintf("Only class IN is served, not %s", class))
+	}
+
+	qtype, err := parseQueryType(tokens[0].text)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case hasTTL:
+	case parser.hasDefaultTTL:
+		ttl = parser.defaultTTL
+	case parser.hasLastTTL:
+		ttl = parser.lastTTL
+	case qtype == SOA && len(tokens) == 8:
+		// Without any TTL to go on, fall back to the SOA minimum as older servers did
+		minimum, ok := parseTTL(tokens[7].text)
+		if !ok {
+			return InvalidInput(fmt.Sprintf("Invalid SOA minimum %s", tokens[7].text))
+		}
+		ttl = minimum
+	default:
+		return InvalidInput("No TTL given and no $TTL set")
+	}
+	parser.lastTTL, parser.hasLastTTL = ttl, true
+
+	record, err := parser.parseRData(owner, qtype, ttl, tokens[1:])
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", owner, qtype, err)
+	}
+
+	parser.records = append(parser.records, record)
+	return nil
+}
+
+func (parser *zoneParser) parseDirective(path string, tokens []zoneToken, depth int) error {
+	switch strings.ToUpper(tokens[0].text) {
+	case "$ORIGIN":
+		if len(tokens) != 2 {
+			return InvalidInput("$ORIGIN takes one name")
+		}
+
+		origin, err := parser.name(tokens[1].text)
+		if err != nil {
+			return err
+		}
+		parser.origin = origin
+	case "$TTL":
+		if len(tokens) != 2 {
+			return InvalidInput("$TTL takes one value")
+		}
+
+		ttl, ok := parseTTL(tokens[1].text)
+		if !ok {
+			return InvalidInput(fmt.Sprintf("Invalid $TTL %s", tokens[1].text))
+		}
+		parser.defaultTTL, parser.hasDefaultTTL = ttl, true
+	case "$INCLUDE":
+		if len(tokens) < 2 || len(tokens) > 3 {
+			return InvalidInput("$INCLUDE takes a file and an optional origin")
+		}
+
+		included, err := decodeText(tokens[1].text)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(included) {
+			included = filepath.Join(filepath.Dir(path), included)
+		}
+
+		// The included file gets its own origin, and ours comes back after it
+		origin, owner := parser.origin, parser.owner
+		if len(tokens) == 3 {
+			if parser.origin, err = parser.name(tokens[2].text); err != nil {
+				return err
+			}
+		}
+
+		err = parser.parseFile(included, depth+1)
+		parser.origin, parser.owner = origin, owner
+		return err
+	default:
+		return InvalidInput(fmt.Sprintf("Unknown directive %s", tokens[0].text))
+	}
+
+	return nil
+}
+
+// name turns a domain name as written in the file into the absolute,
+// dotless form the rest of the project uses
+func (parser *zoneParser) name(text string) (string, error) {
+	if text == "@" {
+		return parser.origin, nil
+	}
+
+	name, err := decodeText(text)
+	if err != nil {
+		return "", err
+	}
+
+	if name == "." {
+		return "", nil
+	}
+
+	if strings.HasSuffix(text, ".") && !strings.HasSuffix(text, "\\.") {
+		return strings.TrimSuffix(name, "."), nil
+	}
+
+	if strings.Contains(name, "..") || strings.HasPrefix(name, ".") {
+		return "", InvalidInput(fmt.Sprintf("Invalid name %s", text))
+	}
+
+	if len(parser.origin) == 0 {
+		return name, nil
+	}
+
+	return name + "." + parser.origin, nil
+}
+
+// parseRData builds a record of qtype from its RDATA fields
+func (parser *zoneParser) parseRData(owner string, qtype QueryType, ttl uint32, fields []zoneToken) (Record, error) {
+	if len(fields) > 0 && !fields[0].quoted && fields[0].text == `\#` {
+		return parseGenericRData(owner, qtype, ttl, fields[1:])
+	}
+
+	texts := make([]string, len(fields))
+	for idx, field := range fields {
+		texts[idx] = field.text
+	}
+
+	need := func(count int) error {
+		if len(fields) != count {
+			return InvalidInput(fmt.Sprintf("Expected %d fields, found %d", count, len(fields)))
+		}
+		return nil
+	}
+
+	atLeast := func(count int) error {
+		if len(fields) < count {
+			return InvalidInput(fmt.Sprintf("Expected at least %d fields, found %d", count, len(fields)))
+		}
+		return nil
+	}
+
+	switch qtype {
+	case A:
+		if err := need(1); err != nil {
+			return nil, err
+		}
+
+		addr := net.ParseIP(texts[0])
+		if addr == nil || addr.To4() == nil || strings.Contains(texts[0], ":") {
+			return nil, InvalidInput(fmt.Sprintf("Invalid IPv4 address %s", texts[0]))
+		}
+		return ARecord{owner, addr, ttl, IN}, nil
+	case AAAA:
+		if err := need(1); err != nil {
+			return nil, err
+		}
+
+		addr := net.ParseIP(texts[0])
+		if addr == nil || !strings.Contains(texts[0], ":") {
+			return nil, InvalidInput(fmt.Sprintf("Invalid IPv6 address %s", texts[0]))
+		}
+		return AaaaRecord{owner, addr, ttl, IN}, nil
+	case NS, CNAME, DNAME, PTR:
+		if err := need(1); err != nil {
+			return nil, err
+		}
+
+		host, err := parser.name(texts[0])
+		if err != nil {
+			return nil, err
+		}
+
+		switch qtype {
+		case NS:
+			return NsRecord{owner, host, ttl, IN}, nil
+		case CNAME:
+			return CNameRecord{owner, host, ttl, IN}, nil
+		case DNAME:
+			return DnameRecord{owner, host, ttl, IN}, nil
+		default:
+			return PtrRecord{owner, host, ttl, IN}, nil
+		}
+	case SOA:
+		if err := need(7); err != nil {
+			return nil, err
+		}
+
+		mname, err := parser.name(texts[0])
+		if err != nil {
+			return nil, err
+		}
+
+		rname, err := parser.name(texts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		serial, err := strconv.ParseUint(texts[2], 10, 32)
+		if err != nil {
+			return nil, InvalidInput(fmt.Sprintf("Invalid serial %s", texts[2]))
+		}
+
+		var timers [4]uint32
+		for idx := range timers {
+			value, ok := parseTTL(texts[3+idx])
+			if !ok {
+				return nil, InvalidInput(fmt.Sprintf("Invalid SOA timer %s", texts[3+idx]))
+			}
+			timers[idx] = value
+		}
+		return SoaRecord{owner, mname, rname, uint32(serial), timers[0], timers[1], timers[2], timers[3], ttl, IN}, nil
+	case MX:
+		if err := need(2); err != nil {
+			return nil, err
+		}
+
+		priority, err := parseUint16(texts[0])
+		if err != nil {
+			return nil, err
+		}
+
+		host, err := parser.name(texts[1])
+		if err != nil {
+			return nil, err
+		}
+		return MxRecord{owner, priority, host, ttl, IN}, nil
+	case TXT:
+		if err := atLeast(1); err != nil {
+			return nil, err
+		}
+
+		strs := make([]string, len(texts))
+		for idx, text := range texts {
+			str, err := parseCharacterString(text)
+			if err != nil {
+				return nil, err
+			}
+			strs[idx] = str
+		}
+		return TxtRecord{owner, strs, ttl, IN}, nil
+	case SRV:
+		if err := need(4); err != nil {
+			return nil, err
+		}
+
+		var values [3]uint16
+		for idx := range values {
+			value, err := parseUint16(texts[idx])
+			if err != nil {
+				return nil, err
+			}
+			values[idx] = value
+		}
+
+		target, err := parser.name(texts[3])
+		if err != nil {
+			return nil, err
+		}
+		return SrvRecord{owner, values[0], values[1], values[2], target, ttl, IN}, nil
+	case NAPTR:
+		if err := need(6); err != nil {
+			return nil, err
+		}
+
+		var values [2]uint16
+		for idx := range values {
+			value, err := parseUint16(texts[idx])
+			if err != nil {
+				return nil, err
+			}
+			values[idx] = value
+		}
+
+		var strs [3]string
+		for idx := range strs {
+			str, err := parseCharacterString(texts[2+idx])
+			if err != nil {
+				return nil, err
+			}
+			strs[idx] = str
+		}
+
+		replacement, err := parser.name(texts[5])
+		if err != nil {
+			return nil, err
+		}
+		return NaptrRecord{owner, values[0], values[1], strs[0], strs[1], strs[2], replacement, ttl, IN}, nil
+	case CAA:
+		if err := need(3); err != nil {
+			return nil, err
+		}
+
+		flags, err := strconv.ParseUint(texts[0], 10, 8)
+		if err != nil {
+			return nil, InvalidInput(fmt.Sprintf("Invalid CAA flags %s", texts[0]))
+		}
+
+		value, err := decodeText(texts[2])
+		if err != nil {
+			return nil, err
+		}
+		return CaaRecord{owner, uint8(flags), texts[1], value, ttl, IN}, nil
+	case SVCB, HTTPS:
+		if err := atLeast(2); err != nil {
+			return nil, err
+		}
+
+		priority, err := parseUint16(texts[0])
+		if err != nil {
+			return nil, err
+		}
+
+		target, err := parser.name(texts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		// Each key may appear only once (RFC 9460 section 2.2)
+		params := make([]SvcParam, 0, len(texts)-2)
+		seen := make(map[SvcParamKey]bool)
+		for _, text := range texts[2:] {
+			param, err := parseSvcParam(text)
+			if err != nil {
+				return nil, err
+			}
+
+			if seen[param.Key()] {
+				return nil, InvalidInput(fmt.Sprintf("Duplicate SvcParamKey %s", param.Key()))
+			}
+			seen[param.Key()] = true
+			params = append(params, param)
+		}
+
+		svcbRecord := SvcbRecord{owner, priority, target, params, ttl, IN}
+		if qtype == HTTPS {
+			return HttpsRecord{svcbRecord}, nil
+		}
+		return svcbRecord, nil
+	case DS:
+		if err := atLeast(4); err != nil {
+			return nil, err
+		}
+
+		keyTag, err := parseUint16(texts[0])
+		if err != nil {
+			return nil, err
+		}
+
+		var values [2]uint8
+		for idx := range values {
+			value, err := strconv.ParseUint(texts[1+idx], 10, 8)
+			if err != nil {
+				return nil, InvalidInput(fmt.Sprintf("Invalid number %s", texts[1+idx]))
+			}
+			values[idx] = uint8(value)
+		}
+
+		digest, err := hex.DecodeString(strings.Join(texts[3:], ""))
+		if err != nil {
+			return nil, InvalidInput("Invalid DS digest")
+		}
+		return DsRecord{owner, keyTag, values[0], values[1], digest, ttl, IN}, nil
+	case DNSKEY:
+		if err := atLeast(4); err != nil {
+			return nil, err
+		}
+
+		flags, err := parseUint16(texts[0])
+		if err != nil {
+			return nil, err
+		}
+
+		var values [2]uint8
+		for idx := range values {
+			value, err := strconv.ParseUint(texts[1+idx], 10, 8)
+			if err != nil {
+				return nil, InvalidInput(fmt.Sprintf("Invalid number %s", texts[1+idx]))
+			}
+			values[idx] = uint8(value)
+		}
+
+		publicKey, err := base64.StdEncoding.DecodeString(strings.Join(texts[3:], ""))
+		if err != nil {
+			return nil, InvalidInput("Invalid DNSKEY public key")
+		}
+		return DnskeyRecord{owner, flags, values[0], values[1], publicKey, ttl, IN}, nil
+	case RRSIG:
+		if err := atLeast(9); err != nil {
+			return nil, err
+		}
+
+		typeCovered, err := parseQueryType(texts[0])
+		if err != nil {
+			return nil, err
+		}
+
+		var values [2]uint8
+		for idx := range values {
+			value, err := strconv.ParseUint(texts[1+idx], 10, 8)
+			if err != nil {
+				return nil, InvalidInput(fmt.Sprintf("Invalid number %s", texts[1+idx]))
+			}
+			values[idx] = uint8(value)
+		}
+
+		originalTTL, ok := parseTTL(texts[3])
+		if !ok {
+			return nil, InvalidInput(fmt.Sprintf("Invalid original TTL %s", texts[3]))
+		}
+
+		var times [2]uint32
+		for idx := range times {
+			value, err := parseSigTime(texts[4+idx])
+			if err != nil {
+				return nil, err
+			}
+			times[idx] = value
+		}
+
+		keyTag, err := parseUint16(texts[6])
+		if err != nil {
+			return nil, err
+		}
+
+		signerName, err := parser.name(texts[7])
+		if err != nil {
+			return nil, err
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(strings.Join(texts[8:], ""))
+		if err != nil {
+			return nil, InvalidInput("Invalid RRSIG signature")
+		}
+		return RrsigRecord{owner, typeCovered, values[0], values[1], originalTTL, times[0], times[1], keyTag, signerName, signature, ttl, IN}, nil
+	case NSEC:
+		if err := atLeast(1); err != nil {
+			return nil, err
+		}
+
+		next, err := parser.name(texts[0])
+		if err != nil {
+			return nil, err
+		}
+
+		types, err := parseTypeList(texts[1:])
+		if err != nil {
+			return nil, err
+		}
+		return NsecRecord{owner, next, types, ttl, IN}, nil
+	case NSEC3:
+		if err := atLeast(5); err != nil {
+			return nil, err
+		}
+
+		var values [2]uint8
+		for idx := range values {
+			value, err := strconv.ParseUint(texts[idx], 10, 8)
+			if err != nil {
+				return nil, InvalidInput(fmt.Sprintf("Invalid number %s", texts[idx]))
+			}
+			values[idx] = uint8(value)
+		}
+
+		iterations, err := parseUint16(texts[2])
+		if err != nil {
+			return nil, err
+		}
+
+		salt := []byte{}
+		if texts[3] != "-" {
+			if salt, err = hex.DecodeString(texts[3]); err != nil {
+				return nil, InvalidInput(fmt.Sprintf("Invalid NSEC3 salt %s", texts[3]))
+			}
+		}
+
+		next, err := base32Hex.DecodeString(strings.ToUpper(texts[4]))
+		if err != nil {
+			return nil, InvalidInput(fmt.Sprintf("Invalid NSEC3 next hash %s", texts[4]))
+		}
+
+		types, err := parseTypeList(texts[5:])
+		if err != nil {
+			return nil, err
+		}
+		return Nsec3Record{owner, values[0], values[1], iterations, salt, next, types, ttl, IN}, nil
+	default:
+		return nil, InvalidInput(fmt.Sprintf("Type %s has no text format here, use \\# instead", formatTypes([]QueryType{qtype})))
+	}
+}
+
+// parseGenericRData builds a record from RDATA written in the RFC 3597
+// "\# length hex" form, decoding it like the same data off the wire
+func parseGenericRData(owner string, qtype QueryType, ttl uint32, fields []zoneToken) (Record, error) {
+	if len(fields) == 0 {
+		return nil, InvalidInput(`\# needs a length`)
+	}
+
+	size, err := strconv.ParseUint(fields[0].text, 10, 16)
+	if err != nil {
+		return nil, InvalidInput(fmt.Sprintf("Invalid RDATA length %s", fields[0].text))
+	}
+
+	hexData := ""
+	for _, field := range fields[1:] {
+		hexData += field.text
+	}
+
+	data, err := hex.DecodeString(hexData)
+	if err != nil || len(data) != int(size) {
+		return nil, InvalidInput(fmt.Sprintf("RDATA does not match its length %d", size))
+	}
+
+	buffer := NewBytePacketBuffer(MaxMessageSize)
+	if err := buffer.writeUncompressedQName(owner); err != nil {
+		return nil, err
+	}
+	for _, field := range []uint16{uint16(qtype), uint16(IN)} {
+		if err := buffer.writeU16(field); err != nil {
+			return nil, err
+		}
+	}
+	if err := buffer.writeU32(ttl); err != nil {
+		return nil, err
+	}
+	if err := buffer.writeU16(uint16(size)); err != nil {
+		return nil, err
+	}
+	if err := buffer.writeBytes(data); err != nil {
+		return nil, err
+	}
+
+	if err := buffer.Seek(0); err != nil {
+		return nil, err
+	}
+
+	return ReadRecord(buffer)
+}
+
+func parseUint16(text string) (uint16, error) {
+	value, err := strconv.ParseUint(text, 10, 16)
+	if err != nil {
+		return 0, InvalidInput(fmt.Sprintf("Invalid number %s", text))
+	}
+
+	return uint16(value), nil
+}
+
+// parseCharacterString decodes a field into a character-string, which holds
+// at most 255 bytes
+func parseCharacterString(text string) (string, error) {
+	str, err := decodeText(text)
+	if err != nil {
+		return "", err
+	}
+
+	if len(str) > 255 {
+		return "", InvalidInput(fmt.Sprintf("String longer than 255 bytes: %q", str))
+	}
+
+	return str, nil
+}
+
+// parseSigTime reads an RRSIG timestamp, written either as YYYYMMDDHHmmSS
+// in UTC or as seconds since the epoch (RFC 4034 section 3.2)
+func parseSigTime(text string) (uint32, error) {
+	if len(text) == 14 && isDigits(text) {
+		timestamp, err := time.Parse("20060102150405", text)
+		if err != nil {
+			return 0, InvalidInput(fmt.Sprintf("Invalid timestamp %s", text))
+		}
+		return uint32(timestamp.Unix()), nil
+	}
+
+	value, err := strconv.ParseUint(text, 10, 32)
+	if err != nil {
+		return 0, InvalidInput(fmt.Sprintf("Invalid timestamp %s", text))
+	}
+
+	return uint32(value), nil
+}
+
+func parseTypeList(texts []string) ([]QueryType, error) {
+	types := make([]QueryType, len(texts))
+	for idx, text := range texts {
+		qtype, err := parseQueryType(text)
+		if err != nil {
+			return nil, err
+		}
+		types[idx] = qtype
+	}
+
+	return types, nil
+}
+
+// parseSvcParamKey reads a parameter name, or the generic keyNNNNN form
+func parseSvcParamKey(text string) (SvcParamKey, error) {
+	for key := MandatoryKey; key <= Ipv6HintKey; key++ {
+		if key.String() == text {
+			return key, nil
+		}
+	}
+
+	if number, ok := strings.CutPrefix(text, "key"); ok {
+		if value, err := strconv.ParseUint(number, 10, 16); err == nil {
+			return SvcParamKey(value), nil
+		}
+	}
+
+	return 0, InvalidInput(fmt.Sprintf("Unknown SvcParamKey %s", text))
+}
+
+// parseSvcParam reads one key=value parameter of an SVCB or HTTPS record
+// (RFC 9460 section 2.1)
+func parseSvcParam(text string) (SvcParam, error) {
+	name, value, hasValue := strings.Cut(text, "=")
+	key, err := parseSvcParamKey(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var param SvcParam
+	switch key {
+	case MandatoryKey:
+		keys := []SvcParamKey{}
+		for _, part := range strings.Split(value, ",") {
+			mandatory, err := parseSvcParamKey(part)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, mandatory)
+		}
+		param = MandatoryParam{keys}
+	case AlpnKey:
+		protocols, err := splitValueList(value)
+		if err != nil {
+			return nil, err
+		}
+		param = AlpnParam{protocols}
+	case NoDefaultAlpnKey:
+		if hasValue {
+			return nil, InvalidInput("no-default-alpn must not have a value")
+		}
+		param = NoDefaultAlpnParam{}
+	case PortKey:
+		port, err := parseUint16(value)
+		if err != nil {
+			return nil, err
+		}
+		param = PortParam{port}
+	case Ipv4HintKey, Ipv6HintKey:
+		addrs := []net.IP{}
+		for _, part := range strings.Split(value, ",") {
+			addr := net.ParseIP(part)
+			if addr == nil || strings.Contains(part, ":") != (key == Ipv6HintKey) {
+				return nil, InvalidInput(fmt.Sprintf("Invalid %s address %s", key, part))
+			}
+			addrs = append(addrs, addr)
+		}
+
+		if key == Ipv4HintKey {
+			param = Ipv4HintParam{addrs}
+		} else {
+			param = Ipv6HintParam{addrs}
+		}
+	case EchKey:
+		config, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, InvalidInput("Invalid ech parameter")
+		}
+		param = EchParam{config}
+	default:
+		raw, err := decodeText(value)
+		if err != nil {
+			return nil, err
+		}
+		param = UnknownParam{key, []byte(raw)}
+	}
+
+	// Packing checks the value the same way writing the record later will
+	if _, err := param.pack(); err != nil {
+		return nil, err
+	}
+
+	return param, nil
+}